@@ -0,0 +1,139 @@
+// Package bench holds classic Lox benchmark programs, run by go test -bench,
+// and the comparison of two saved runs printed by glox bench.
+//
+//	go test -bench . -count 5 ./bench > old.txt
+//	(change the VM)
+//	go test -bench . -count 5 ./bench > new.txt
+//	glox bench old.txt new.txt
+package bench
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Result is the mean of every run of one benchmark in a go test -bench log.
+type Result struct {
+	NsPerOp     float64
+	AllocsPerOp float64 // 0 unless the benchmark reported allocations
+	Runs        int
+}
+
+// ParseResults reads the output of go test -bench. Runs of the same
+// benchmark, as produced by -count, are averaged. Lines that are not
+// benchmark results are skipped.
+func ParseResults(r io.Reader) (map[string]Result, error) {
+	sums := make(map[string]Result)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			continue // not an iteration count, so not a result line
+		}
+		name := benchmarkName(fields[0])
+		sum := sums[name]
+		// the rest of the line is value/unit pairs
+		for i := 2; i+1 < len(fields); i += 2 {
+			value, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: bad %s value %q", name, fields[i+1], fields[i])
+			}
+			switch fields[i+1] {
+			case "ns/op":
+				sum.NsPerOp += value
+			case "allocs/op":
+				sum.AllocsPerOp += value
+			}
+		}
+		sum.Runs++
+		sums[name] = sum
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for name, sum := range sums {
+		sums[name] = Result{sum.NsPerOp / float64(sum.Runs), sum.AllocsPerOp / float64(sum.Runs), sum.Runs}
+	}
+	return sums, nil
+}
+
+// benchmarkName drops the Benchmark prefix and the -GOMAXPROCS suffix go
+// test appends, so runs on different machines compare.
+func benchmarkName(name string) string {
+	name = strings.TrimPrefix(name, "Benchmark")
+	if i := strings.LastIndexByte(name, '-'); i >= 0 {
+		if _, err := strconv.Atoi(name[i+1:]); err == nil {
+			name = name[:i]
+		}
+	}
+	return name
+}
+
+// WriteComparison prints a table of time and allocations per run for every
+// benchmark in old or new, with the change from old to new.
+func WriteComparison(out io.Writer, old, new map[string]Result) error {
+	names := slices.Sorted(maps.Keys(old))
+	for name := range new {
+		if _, ok := old[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "benchmark\told time/op\tnew time/op\tdelta\told allocs/op\tnew allocs/op\tdelta\t")
+	for _, name := range names {
+		o, inOld := old[name]
+		n, inNew := new[name]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", name,
+			column(inOld, formatTime(o.NsPerOp)), column(inNew, formatTime(n.NsPerOp)),
+			delta(inOld && inNew, o.NsPerOp, n.NsPerOp),
+			column(inOld, formatCount(o.AllocsPerOp)), column(inNew, formatCount(n.AllocsPerOp)),
+			delta(inOld && inNew, o.AllocsPerOp, n.AllocsPerOp))
+	}
+	return w.Flush()
+}
+
+func column(ok bool, s string) string {
+	if !ok {
+		return "-"
+	}
+	return s
+}
+
+func delta(ok bool, old, new float64) string {
+	switch {
+	case !ok:
+		return "-"
+	case old == new:
+		return "~"
+	case old == 0:
+		return "+inf%"
+	}
+	return fmt.Sprintf("%+.1f%%", (new-old)/old*100)
+}
+
+func formatTime(ns float64) string {
+	switch {
+	case ns >= 1e9:
+		return fmt.Sprintf("%.2fs", ns/1e9)
+	case ns >= 1e6:
+		return fmt.Sprintf("%.2fms", ns/1e6)
+	case ns >= 1e3:
+		return fmt.Sprintf("%.2fµs", ns/1e3)
+	}
+	return fmt.Sprintf("%.0fns", ns)
+}
+
+func formatCount(n float64) string {
+	return fmt.Sprintf("%.0f", n)
+}
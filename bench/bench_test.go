@@ -0,0 +1,116 @@
+package bench
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"glox/lox"
+)
+
+type program struct {
+	name   string
+	source string
+}
+
+func loadPrograms(tb testing.TB) []program {
+	tb.Helper()
+	paths, err := filepath.Glob("testdata/*.lox")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var programs []program
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		programs = append(programs, program{strings.TrimSuffix(filepath.Base(path), ".lox"), string(src)})
+	}
+	return programs
+}
+
+// BenchmarkPrograms times one run of each program, compiled ahead of time,
+// on a VM that is reused between runs as the REPL does.
+func BenchmarkPrograms(b *testing.B) {
+	for _, p := range loadPrograms(b) {
+		b.Run(p.name, func(b *testing.B) {
+			config := lox.Config{Stdout: io.Discard}
+			function, err := lox.Compile(p.source, config)
+			if err != nil {
+				b.Fatal(err)
+			}
+			vm := lox.NewVM(config)
+			b.ReportAllocs()
+			for b.Loop() {
+				if err := vm.Interpret(function); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestProgramsRun(t *testing.T) {
+	for _, p := range loadPrograms(t) {
+		t.Run(p.name, func(t *testing.T) {
+			t.Parallel()
+			config := lox.Config{Stdout: io.Discard}
+			function, err := lox.Compile(p.source, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := lox.NewVM(config).Interpret(function); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+const oldLog = `goos: linux
+goarch: amd64
+pkg: glox/bench
+BenchmarkPrograms/fib-8         	     160	   7000000 ns/op	 2000000 B/op	   90000 allocs/op
+BenchmarkPrograms/fib-8         	     160	   9000000 ns/op	 2000000 B/op	   90000 allocs/op
+BenchmarkPrograms/zoo-8         	      50	  24000000 ns/op	 5000000 B/op	  300000 allocs/op
+PASS
+ok  	glox/bench	5.123s
+`
+
+const newLog = `BenchmarkPrograms/fib-16        	     200	   6000000 ns/op	 1000000 B/op	   45000 allocs/op
+BenchmarkPrograms/closures-16   	     300	    900000 ns/op	  100000 B/op	    4000 allocs/op
+BenchmarkPrograms/zoo-16        	      50	  24000000 ns/op	 5000000 B/op	  300000 allocs/op
+`
+
+func TestParseResultsAveragesRuns(t *testing.T) {
+	results, err := ParseResults(strings.NewReader(oldLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Result{NsPerOp: 8000000, AllocsPerOp: 90000, Runs: 2}
+	if got := results["Programs/fib"]; got != want {
+		t.Errorf("Programs/fib = %+v, want %+v", got, want)
+	}
+	if len(results) != 2 {
+		t.Errorf("got %d benchmarks, want 2: %v", len(results), results)
+	}
+}
+
+func TestWriteComparison(t *testing.T) {
+	old, _ := ParseResults(strings.NewReader(oldLog))
+	new, _ := ParseResults(strings.NewReader(newLog))
+	var out strings.Builder
+	if err := WriteComparison(&out, old, new); err != nil {
+		t.Fatal(err)
+	}
+	want := `          benchmark  old time/op  new time/op   delta  old allocs/op  new allocs/op   delta
+  Programs/closures            -     900.00µs       -              -           4000       -
+       Programs/fib       8.00ms       6.00ms  -25.0%          90000          45000  -50.0%
+       Programs/zoo      24.00ms      24.00ms       ~         300000         300000       ~
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
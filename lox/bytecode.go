@@ -0,0 +1,353 @@
+package lox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+/*
+Layout of a .loxc file, all integers are unsigned varints unless noted:
+
+	magic      "GLOXC\x00"
+	version    u16 big-endian
+	globals    count, then the name of each global slot as a string
+	caches     number of inline cache slots
+	function   the top-level script
+
+function:
+
+	name       string
+	file       string, the source file name
+	arity
+	upvalues   number of upvalues; their descriptors follow the
+	           OP_CLOSURE that creates the function
+	code       length, then the raw bytecode
+	positions  number of runs, then (count, line, column, offset) per run
+	           of bytes that share a source position
+	constants  count, then one tagged constant each
+
+constant:
+
+	tag byte   CONST_NIL | CONST_FALSE | CONST_TRUE | CONST_NUMBER | CONST_STRING | CONST_FUNCTION
+	payload    number: 8-byte big-endian IEEE 754, string: string, function: function
+
+string:
+
+	length, then UTF-8 bytes
+*/
+
+const BYTECODE_VERSION uint16 = 4
+
+var bytecodeMagic = []byte("GLOXC\x00")
+
+const (
+	CONST_NIL byte = iota
+	CONST_FALSE
+	CONST_TRUE
+	CONST_NUMBER
+	CONST_STRING
+	CONST_FUNCTION
+)
+
+// ErrBadBytecode is wrapped by every error UnmarshalFunction returns for
+// malformed input.
+var ErrBadBytecode = errors.New("invalid bytecode")
+
+// IsBytecode reports whether data starts with the .loxc magic number.
+func IsBytecode(data []byte) bool {
+	return bytes.HasPrefix(data, bytecodeMagic)
+}
+
+// MarshalFunction serializes a compiled script, including every function
+// nested in its constant pool.
+func MarshalFunction(function *LoxFunction) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(bytecodeMagic)
+	buf.Write(binary.BigEndian.AppendUint16(nil, BYTECODE_VERSION))
+	writeUvarint(&buf, len(function.chunk.globals))
+	for _, name := range function.chunk.globals {
+		writeString(&buf, name)
+	}
+	writeUvarint(&buf, function.chunk.caches)
+	if err := marshalFunction(&buf, function); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeUvarint(buf *bytes.Buffer, n int) {
+	buf.Write(binary.AppendUvarint(nil, uint64(n)))
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	writeUvarint(buf, len(s))
+	buf.WriteString(s)
+}
+
+func marshalFunction(buf *bytes.Buffer, function *LoxFunction) error {
+	writeString(buf, function.name)
+	writeString(buf, function.chunk.file)
+	writeUvarint(buf, function.arity)
+	writeUvarint(buf, function.upValueCount)
+
+	chunk := &function.chunk
+	writeUvarint(buf, len(chunk.bcodes))
+	buf.Write(chunk.bcodes)
+
+	writeUvarint(buf, len(chunk.positions))
+	for i, run := range chunk.positions {
+		end := len(chunk.bcodes)
+		if i+1 < len(chunk.positions) {
+			end = chunk.positions[i+1].start
+		}
+		writeUvarint(buf, end-run.start)
+		writeUvarint(buf, run.pos.Line)
+		writeUvarint(buf, run.pos.Column)
+		writeUvarint(buf, run.pos.Offset)
+	}
+
+	writeUvarint(buf, len(chunk.constants))
+	for _, constant := range chunk.constants {
+		if err := marshalConstant(buf, constant); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func marshalConstant(buf *bytes.Buffer, constant Value) error {
+	if constant.IsNil() {
+		buf.WriteByte(CONST_NIL)
+	} else if b, ok := constant.GetBool(); ok {
+		if b {
+			buf.WriteByte(CONST_TRUE)
+		} else {
+			buf.WriteByte(CONST_FALSE)
+		}
+	} else if n, ok := constant.GetFloat(); ok {
+		buf.WriteByte(CONST_NUMBER)
+		buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(n)))
+	} else if s, ok := constant.GetString(); ok {
+		buf.WriteByte(CONST_STRING)
+		writeString(buf, s)
+	} else if function, ok := constant.GetFunction(); ok {
+		buf.WriteByte(CONST_FUNCTION)
+		return marshalFunction(buf, function)
+	} else {
+		return fmt.Errorf("can't serialize %s constant '%s'", constant.TypeName(), constant.String())
+	}
+	return nil
+}
+
+// bytecodeReader decodes a .loxc image, checking every length against the
+// bytes that are actually left so malformed input can't cause huge
+// allocations or out-of-range reads.
+type bytecodeReader struct {
+	data   []byte
+	offset int
+}
+
+func (r *bytecodeReader) fail(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s at byte %d", ErrBadBytecode, fmt.Sprintf(format, args...), r.offset)
+}
+
+func (r *bytecodeReader) readBytes(n int) ([]byte, error) {
+	if n < 0 || n > len(r.data)-r.offset {
+		return nil, r.fail("unexpected end of data")
+	}
+	b := r.data[r.offset : r.offset+n]
+	r.offset += n
+	return b, nil
+}
+
+func (r *bytecodeReader) readByte() (byte, error) {
+	b, err := r.readBytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *bytecodeReader) readUvarint() (int, error) {
+	n, size := binary.Uvarint(r.data[r.offset:])
+	if size <= 0 || n > math.MaxInt32 {
+		return 0, r.fail("bad integer")
+	}
+	r.offset += size
+	return int(n), nil
+}
+
+// readCount reads a count of items that each take at least one byte.
+func (r *bytecodeReader) readCount() (int, error) {
+	n, err := r.readUvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > len(r.data)-r.offset {
+		return 0, r.fail("count %d exceeds remaining data", n)
+	}
+	return n, nil
+}
+
+func (r *bytecodeReader) readString() (string, error) {
+	n, err := r.readUvarint()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.readBytes(n)
+	return string(b), err
+}
+
+// UnmarshalFunction loads a script serialized by MarshalFunction and checks
+// it with Verify, since the file may have been corrupted or hand-crafted.
+func UnmarshalFunction(data []byte) (*LoxFunction, error) {
+	r := &bytecodeReader{data: data}
+	if !IsBytecode(data) {
+		return nil, r.fail("missing magic number")
+	}
+	r.offset = len(bytecodeMagic)
+	b, err := r.readBytes(2)
+	if err != nil {
+		return nil, err
+	}
+	if version := binary.BigEndian.Uint16(b); version != BYTECODE_VERSION {
+		return nil, r.fail("unsupported version %d, expected %d", version, BYTECODE_VERSION)
+	}
+	count, err := r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	globals := make([]string, count)
+	for i := range globals {
+		if globals[i], err = r.readString(); err != nil {
+			return nil, err
+		}
+	}
+	caches, err := r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	function, err := r.readFunction()
+	if err != nil {
+		return nil, err
+	}
+	setProgram(function, globals, caches)
+	if r.offset != len(r.data) {
+		return nil, r.fail("trailing data")
+	}
+	if err := Verify(function); err != nil {
+		return nil, err
+	}
+	return function, nil
+}
+
+func (r *bytecodeReader) readFunction() (*LoxFunction, error) {
+	var err error
+	function := NewFunction()
+	if function.name, err = r.readString(); err != nil {
+		return nil, err
+	}
+	if function.chunk.file, err = r.readString(); err != nil {
+		return nil, err
+	}
+	if function.arity, err = r.readUvarint(); err != nil {
+		return nil, err
+	}
+	if function.upValueCount, err = r.readUvarint(); err != nil {
+		return nil, err
+	}
+
+	chunk := &function.chunk
+	codeLen, err := r.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	code, err := r.readBytes(codeLen)
+	if err != nil {
+		return nil, err
+	}
+	chunk.bcodes = append([]byte(nil), code...)
+
+	runs, err := r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	covered := 0
+	for range runs {
+		var count int
+		var pos Position
+		for _, field := range []*int{&count, &pos.Line, &pos.Column, &pos.Offset} {
+			if *field, err = r.readUvarint(); err != nil {
+				return nil, err
+			}
+		}
+		if count == 0 || count > codeLen-covered {
+			return nil, r.fail("bad position run of %d bytes", count)
+		}
+		chunk.positions = append(chunk.positions, positionRun{covered, pos})
+		covered += count
+	}
+	if covered != codeLen {
+		return nil, r.fail("position table covers %d of %d bytes", covered, codeLen)
+	}
+
+	count, err := r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	for range count {
+		constant, err := r.readConstant()
+		if err != nil {
+			return nil, err
+		}
+		chunk.constants = append(chunk.constants, constant)
+	}
+	return function, nil
+}
+
+// setProgram gives function and every function nested in it the global slot
+// names and inline cache count of their program.
+func setProgram(function *LoxFunction, globals []string, caches int) {
+	function.chunk.globals = globals
+	function.chunk.caches = caches
+	for _, constant := range function.chunk.constants {
+		if nested, ok := constant.GetFunction(); ok {
+			setProgram(nested, globals, caches)
+		}
+	}
+}
+
+func (r *bytecodeReader) readConstant() (Value, error) {
+	tag, err := r.readByte()
+	if err != nil {
+		return NilVal(), err
+	}
+	switch tag {
+	case CONST_NIL:
+		return NilVal(), nil
+	case CONST_FALSE:
+		return BoolVal(false), nil
+	case CONST_TRUE:
+		return BoolVal(true), nil
+	case CONST_NUMBER:
+		b, err := r.readBytes(8)
+		if err != nil {
+			return NilVal(), err
+		}
+		return FloatVal(math.Float64frombits(binary.BigEndian.Uint64(b))), nil
+	case CONST_STRING:
+		s, err := r.readString()
+		return StringVal(s), err
+	case CONST_FUNCTION:
+		function, err := r.readFunction()
+		if err != nil {
+			return NilVal(), err
+		}
+		return FunctionVal(function), nil
+	default:
+		return NilVal(), r.fail("unknown constant tag %d", tag)
+	}
+}
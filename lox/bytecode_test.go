@@ -0,0 +1,47 @@
+package lox
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestBytecodeRoundTrip(t *testing.T) {
+	for path, source := range loadTestcases(t) {
+		function, err := Compile(source, Config{})
+		if err != nil {
+			continue
+		}
+		data, err := MarshalFunction(function)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		loaded, err := UnmarshalFunction(data)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		again, err := MarshalFunction(loaded)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if !bytes.Equal(data, again) {
+			t.Errorf("%s: reloaded bytecode differs from the original", path)
+		}
+	}
+}
+
+func TestBytecodeRejectsTruncatedInput(t *testing.T) {
+	function, err := Compile("fun f(a) { return a + 1; } print f(2);", Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := MarshalFunction(function)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := range len(data) {
+		if _, err := UnmarshalFunction(data[:n]); !errors.Is(err, ErrBadBytecode) {
+			t.Errorf("truncated to %d bytes: got error %v", n, err)
+		}
+	}
+}
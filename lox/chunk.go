@@ -0,0 +1,318 @@
+package lox
+
+import "sort"
+
+const (
+	OP_CONSTANT byte = iota + 1 // 1
+	OP_NIL
+	OP_TRUE
+	OP_FALSE
+	OP_NOT
+	OP_NEGATE
+	OP_EQUAL
+	OP_GREATER
+	OP_LESS
+	OP_ADD
+	OP_SUBTRACT
+	OP_MULTIPLY
+	OP_DIVIDE
+	OP_PRINT
+	OP_POP
+	OP_DEFINE_GLOBAL
+	OP_GET_GLOBAL
+	OP_SET_GLOBAL
+	OP_GET_LOCAL
+	OP_SET_LOCAL
+	OP_JUMP
+	OP_JUMP_IF_FALSE
+	OP_LOOP
+	OP_CALL
+	OP_CLOSURE
+	OP_GET_UPVALUE
+	OP_SET_UPVALUE
+	OP_CLOSE_UPVALUE
+	OP_CLASS
+	OP_SET_PROPERTY
+	OP_GET_PROPERTY
+	OP_METHOD
+	OP_INVOKE
+	OP_INHERIT
+	OP_GET_SUPER
+	OP_INVOKE_SUPER
+	OP_RETURN
+	// Forms of the constant-index instructions above with a 24-bit big-endian
+	// index, emitted once a chunk has more than 256 constants.
+	OP_CONSTANT_LONG
+	OP_DEFINE_GLOBAL_LONG
+	OP_GET_GLOBAL_LONG
+	OP_SET_GLOBAL_LONG
+	OP_CLOSURE_LONG
+	OP_CLASS_LONG
+	OP_SET_PROPERTY_LONG
+	OP_GET_PROPERTY_LONG
+	OP_METHOD_LONG
+	OP_INVOKE_LONG
+	OP_GET_SUPER_LONG
+	OP_INVOKE_SUPER_LONG
+	// Wide forms for functions with more than 256 locals or upvalues (u24
+	// operand) and for jumps over more than 64KiB of code (u32 operand).
+	OP_GET_LOCAL_LONG
+	OP_SET_LOCAL_LONG
+	OP_GET_UPVALUE_LONG
+	OP_SET_UPVALUE_LONG
+	OP_JUMP_LONG
+	OP_JUMP_IF_FALSE_LONG
+	OP_LOOP_LONG
+)
+
+const (
+	MAX_CONSTANTS int = 1 << 24
+	MAX_LOCALS    int = 1 << 24
+	MAX_UPVALUES  int = 1 << 24
+)
+
+// Flags of the descriptor byte that precedes each upvalue index after
+// OP_CLOSURE. The index is one byte, or three with UPVALUE_WIDE.
+const (
+	UPVALUE_LOCAL byte = 1 << iota
+	UPVALUE_WIDE
+)
+
+// longOps maps each instruction with a u8 constant index to its u24 form.
+var longOps = map[byte]byte{
+	OP_CONSTANT:      OP_CONSTANT_LONG,
+	OP_DEFINE_GLOBAL: OP_DEFINE_GLOBAL_LONG,
+	OP_GET_GLOBAL:    OP_GET_GLOBAL_LONG,
+	OP_SET_GLOBAL:    OP_SET_GLOBAL_LONG,
+	OP_CLOSURE:       OP_CLOSURE_LONG,
+	OP_CLASS:         OP_CLASS_LONG,
+	OP_SET_PROPERTY:  OP_SET_PROPERTY_LONG,
+	OP_GET_PROPERTY:  OP_GET_PROPERTY_LONG,
+	OP_METHOD:        OP_METHOD_LONG,
+	OP_INVOKE:        OP_INVOKE_LONG,
+	OP_GET_SUPER:     OP_GET_SUPER_LONG,
+	OP_INVOKE_SUPER:  OP_INVOKE_SUPER_LONG,
+	OP_GET_LOCAL:     OP_GET_LOCAL_LONG,
+	OP_SET_LOCAL:     OP_SET_LOCAL_LONG,
+	OP_GET_UPVALUE:   OP_GET_UPVALUE_LONG,
+	OP_SET_UPVALUE:   OP_SET_UPVALUE_LONG,
+	OP_JUMP:          OP_JUMP_LONG,
+	OP_JUMP_IF_FALSE: OP_JUMP_IF_FALSE_LONG,
+	OP_LOOP:          OP_LOOP_LONG,
+}
+
+// Operand layouts that follow an opcode in the byte stream.
+const (
+	OPERAND_NONE          byte = iota
+	OPERAND_CONSTANT           // u8 constant index
+	OPERAND_LOCAL              // u8 stack slot relative to the frame
+	OPERAND_UPVALUE            // u8 index into the closure's upvalues
+	OPERAND_ARGC               // u8 argument count
+	OPERAND_JUMP               // u16 forward offset
+	OPERAND_LOOP               // u16 backward offset
+	OPERAND_INVOKE             // u8 constant index, u16 inline cache slot, u8 argument count
+	OPERAND_CLOSURE            // u8 constant index, then a descriptor per upvalue, see UPVALUE_LOCAL
+	OPERAND_CONSTANT_LONG      // u24 constant index
+	OPERAND_INVOKE_LONG        // u24 constant index, u24 inline cache slot, u8 argument count
+	OPERAND_CLOSURE_LONG       // u24 constant index, then upvalue descriptors as for OPERAND_CLOSURE
+	OPERAND_LOCAL_LONG         // u24 stack slot
+	OPERAND_UPVALUE_LONG       // u24 upvalue index
+	OPERAND_JUMP_LONG          // u32 forward offset
+	OPERAND_LOOP_LONG          // u32 backward offset
+	OPERAND_GLOBAL             // u8 global slot, see Chunk.globals
+	OPERAND_GLOBAL_LONG        // u24 global slot
+	OPERAND_PROPERTY           // u8 constant index, u16 inline cache slot, see Chunk.caches
+	OPERAND_PROPERTY_LONG      // u24 constant index, u24 inline cache slot
+)
+
+// OpInfo describes how an instruction is encoded and how it changes the
+// value stack. Call-like instructions also pop their arguments, which are
+// not counted in pops. The disassembler and the verifier both decode
+// bytecode from this table.
+type OpInfo struct {
+	Name    string
+	Operand byte
+	pops    int
+	pushes  int
+}
+
+var opInfos = [...]OpInfo{
+	OP_CONSTANT:      {"OP_CONSTANT", OPERAND_CONSTANT, 0, 1},
+	OP_NIL:           {"OP_NIL", OPERAND_NONE, 0, 1},
+	OP_TRUE:          {"OP_TRUE", OPERAND_NONE, 0, 1},
+	OP_FALSE:         {"OP_FALSE", OPERAND_NONE, 0, 1},
+	OP_NOT:           {"OP_NOT", OPERAND_NONE, 1, 1},
+	OP_NEGATE:        {"OP_NEGATE", OPERAND_NONE, 1, 1},
+	OP_EQUAL:         {"OP_EQUAL", OPERAND_NONE, 2, 1},
+	OP_GREATER:       {"OP_GREATER", OPERAND_NONE, 2, 1},
+	OP_LESS:          {"OP_LESS", OPERAND_NONE, 2, 1},
+	OP_ADD:           {"OP_ADD", OPERAND_NONE, 2, 1},
+	OP_SUBTRACT:      {"OP_SUBTRACT", OPERAND_NONE, 2, 1},
+	OP_MULTIPLY:      {"OP_MULTIPLY", OPERAND_NONE, 2, 1},
+	OP_DIVIDE:        {"OP_DIVIDE", OPERAND_NONE, 2, 1},
+	OP_PRINT:         {"OP_PRINT", OPERAND_NONE, 1, 0},
+	OP_POP:           {"OP_POP", OPERAND_NONE, 1, 0},
+	OP_DEFINE_GLOBAL: {"OP_DEFINE_GLOBAL", OPERAND_GLOBAL, 1, 0},
+	OP_GET_GLOBAL:    {"OP_GET_GLOBAL", OPERAND_GLOBAL, 0, 1},
+	OP_SET_GLOBAL:    {"OP_SET_GLOBAL", OPERAND_GLOBAL, 1, 1},
+	OP_GET_LOCAL:     {"OP_GET_LOCAL", OPERAND_LOCAL, 0, 1},
+	OP_SET_LOCAL:     {"OP_SET_LOCAL", OPERAND_LOCAL, 1, 1},
+	OP_JUMP:          {"OP_JUMP", OPERAND_JUMP, 0, 0},
+	OP_JUMP_IF_FALSE: {"OP_JUMP_IF_FALSE", OPERAND_JUMP, 1, 1},
+	OP_LOOP:          {"OP_LOOP", OPERAND_LOOP, 0, 0},
+	OP_CALL:          {"OP_CALL", OPERAND_ARGC, 1, 1},
+	OP_CLOSURE:       {"OP_CLOSURE", OPERAND_CLOSURE, 0, 1},
+	OP_GET_UPVALUE:   {"OP_GET_UPVALUE", OPERAND_UPVALUE, 0, 1},
+	OP_SET_UPVALUE:   {"OP_SET_UPVALUE", OPERAND_UPVALUE, 1, 1},
+	OP_CLOSE_UPVALUE: {"OP_CLOSE_UPVALUE", OPERAND_NONE, 1, 0},
+	OP_CLASS:         {"OP_CLASS", OPERAND_CONSTANT, 0, 1},
+	OP_SET_PROPERTY:  {"OP_SET_PROPERTY", OPERAND_CONSTANT, 2, 1},
+	OP_GET_PROPERTY:  {"OP_GET_PROPERTY", OPERAND_PROPERTY, 1, 1},
+	OP_METHOD:        {"OP_METHOD", OPERAND_CONSTANT, 2, 1},
+	OP_INVOKE:        {"OP_INVOKE", OPERAND_INVOKE, 1, 1},
+	OP_INHERIT:       {"OP_INHERIT", OPERAND_NONE, 2, 1},
+	OP_GET_SUPER:     {"OP_GET_SUPER", OPERAND_PROPERTY, 2, 1},
+	OP_INVOKE_SUPER:  {"OP_INVOKE_SUPER", OPERAND_INVOKE, 2, 1},
+	OP_RETURN:        {"OP_RETURN", OPERAND_NONE, 1, 0},
+
+	OP_CONSTANT_LONG:      {"OP_CONSTANT_LONG", OPERAND_CONSTANT_LONG, 0, 1},
+	OP_DEFINE_GLOBAL_LONG: {"OP_DEFINE_GLOBAL_LONG", OPERAND_GLOBAL_LONG, 1, 0},
+	OP_GET_GLOBAL_LONG:    {"OP_GET_GLOBAL_LONG", OPERAND_GLOBAL_LONG, 0, 1},
+	OP_SET_GLOBAL_LONG:    {"OP_SET_GLOBAL_LONG", OPERAND_GLOBAL_LONG, 1, 1},
+	OP_CLOSURE_LONG:       {"OP_CLOSURE_LONG", OPERAND_CLOSURE_LONG, 0, 1},
+	OP_CLASS_LONG:         {"OP_CLASS_LONG", OPERAND_CONSTANT_LONG, 0, 1},
+	OP_SET_PROPERTY_LONG:  {"OP_SET_PROPERTY_LONG", OPERAND_CONSTANT_LONG, 2, 1},
+	OP_GET_PROPERTY_LONG:  {"OP_GET_PROPERTY_LONG", OPERAND_PROPERTY_LONG, 1, 1},
+	OP_METHOD_LONG:        {"OP_METHOD_LONG", OPERAND_CONSTANT_LONG, 2, 1},
+	OP_INVOKE_LONG:        {"OP_INVOKE_LONG", OPERAND_INVOKE_LONG, 1, 1},
+	OP_GET_SUPER_LONG:     {"OP_GET_SUPER_LONG", OPERAND_PROPERTY_LONG, 2, 1},
+	OP_INVOKE_SUPER_LONG:  {"OP_INVOKE_SUPER_LONG", OPERAND_INVOKE_LONG, 2, 1},
+
+	OP_GET_LOCAL_LONG:     {"OP_GET_LOCAL_LONG", OPERAND_LOCAL_LONG, 0, 1},
+	OP_SET_LOCAL_LONG:     {"OP_SET_LOCAL_LONG", OPERAND_LOCAL_LONG, 1, 1},
+	OP_GET_UPVALUE_LONG:   {"OP_GET_UPVALUE_LONG", OPERAND_UPVALUE_LONG, 0, 1},
+	OP_SET_UPVALUE_LONG:   {"OP_SET_UPVALUE_LONG", OPERAND_UPVALUE_LONG, 1, 1},
+	OP_JUMP_LONG:          {"OP_JUMP_LONG", OPERAND_JUMP_LONG, 0, 0},
+	OP_JUMP_IF_FALSE_LONG: {"OP_JUMP_IF_FALSE_LONG", OPERAND_JUMP_LONG, 1, 1},
+	OP_LOOP_LONG:          {"OP_LOOP_LONG", OPERAND_LOOP_LONG, 0, 0},
+}
+
+// operandWidth is the size in bytes of the slot, index, argument count or
+// jump offset that follows an instruction. For property, invoke and closure
+// instructions it is the size of the constant index only.
+func operandWidth(operand byte) int {
+	switch operand {
+	case OPERAND_NONE:
+		return 0
+	case OPERAND_JUMP, OPERAND_LOOP:
+		return 2
+	case OPERAND_CONSTANT_LONG, OPERAND_INVOKE_LONG, OPERAND_CLOSURE_LONG, OPERAND_LOCAL_LONG, OPERAND_UPVALUE_LONG, OPERAND_GLOBAL_LONG, OPERAND_PROPERTY_LONG:
+		return 3
+	case OPERAND_JUMP_LONG, OPERAND_LOOP_LONG:
+		return 4
+	}
+	return 1
+}
+
+// upvalueDescriptor decodes the upvalue capture at offset following
+// OP_CLOSURE and returns the offset of the next one.
+func (chunk *Chunk) upvalueDescriptor(offset int) (isLocal bool, index int, next int) {
+	flags := chunk.bcodes[offset]
+	width := 1
+	if flags&UPVALUE_WIDE != 0 {
+		width = 3
+	}
+	return flags&UPVALUE_LOCAL != 0, chunk.readIndex(offset+1, width), offset + 1 + width
+}
+
+// constantWidth is the size in bytes of the constant index that follows an
+// instruction, or 0 if it has none.
+func constantWidth(operand byte) int {
+	switch operand {
+	case OPERAND_CONSTANT, OPERAND_INVOKE, OPERAND_CLOSURE, OPERAND_PROPERTY:
+		return 1
+	case OPERAND_CONSTANT_LONG, OPERAND_INVOKE_LONG, OPERAND_CLOSURE_LONG, OPERAND_PROPERTY_LONG:
+		return 3
+	}
+	return 0
+}
+
+// cacheWidth is the size in bytes of the inline cache slot that follows the
+// constant index of an instruction, or 0 if it has none.
+func cacheWidth(operand byte) int {
+	switch operand {
+	case OPERAND_PROPERTY, OPERAND_INVOKE:
+		return 2
+	case OPERAND_PROPERTY_LONG, OPERAND_INVOKE_LONG:
+		return 3
+	}
+	return 0
+}
+
+// readIndex decodes the big-endian operand of width bytes at offset.
+func (chunk *Chunk) readIndex(offset int, width int) int {
+	index := 0
+	for i := 0; i < width; i++ {
+		index = index<<8 | int(chunk.bcodes[offset+i])
+	}
+	return index
+}
+
+// LookupOp returns the encoding of opcode, or false if it is not a valid
+// instruction.
+func LookupOp(opcode byte) (OpInfo, bool) {
+	if int(opcode) >= len(opInfos) || opInfos[opcode].Name == "" {
+		return OpInfo{}, false
+	}
+	return opInfos[opcode], true
+}
+
+type Chunk struct {
+	bcodes    []byte
+	file      string        // source file the code was compiled from
+	positions []positionRun // run-length encoded source position of each byte in bcodes
+	constants []Value
+	globals   []string // name of each global slot of the program, indexed by the operand of OP_*_GLOBAL
+	caches    int      // inline cache slots of the program, see OPERAND_PROPERTY
+}
+
+// positionRun is the source position of the bytes from start up to the start
+// of the next run. File is left empty, it is the same for the whole chunk.
+type positionRun struct {
+	start int
+	pos   Position
+}
+
+func WriteChunk(chunk *Chunk, bcode byte, pos Position) {
+	pos.File = ""
+	if n := len(chunk.positions); n == 0 || chunk.positions[n-1].pos != pos {
+		chunk.positions = append(chunk.positions, positionRun{len(chunk.bcodes), pos})
+	}
+	chunk.bcodes = append(chunk.bcodes, bcode)
+}
+
+// truncate drops the code from offset on.
+func (chunk *Chunk) truncate(offset int) {
+	chunk.bcodes = chunk.bcodes[:offset]
+	for n := len(chunk.positions); n > 0 && chunk.positions[n-1].start >= offset; n-- {
+		chunk.positions = chunk.positions[:n-1]
+	}
+}
+
+// Position returns the source position of the instruction byte at offset.
+func (chunk *Chunk) Position(offset int) Position {
+	i := sort.Search(len(chunk.positions), func(i int) bool {
+		return chunk.positions[i].start > offset
+	})
+	if i == 0 {
+		return Position{File: chunk.file}
+	}
+	pos := chunk.positions[i-1].pos
+	pos.File = chunk.file
+	return pos
+}
+
+func AddConstant(chunk *Chunk, c Value) int {
+	chunk.constants = append(chunk.constants, c)
+	return len(chunk.constants) - 1
+}
@@ -0,0 +1,34 @@
+package lox
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLongConstants(t *testing.T) {
+	var src strings.Builder
+	for i := range 300 {
+		fmt.Fprintf(&src, "var v%d = %d;\n", i, i)
+	}
+	src.WriteString("print v0 + v299 == 299;\n")
+
+	function, err := Compile(src.String(), Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(function.chunk.constants); n <= 256 {
+		t.Fatalf("chunk has %d constants, want more than 256", n)
+	}
+	var out bytes.Buffer
+	DisassembleFunction(&out, function)
+	for _, op := range []string{"OP_CONSTANT_LONG", "OP_DEFINE_GLOBAL_LONG", "OP_GET_GLOBAL_LONG"} {
+		if !strings.Contains(out.String(), op) {
+			t.Errorf("disassembly has no %s", op)
+		}
+	}
+	if got := runScript(src.String()); got != "true\n" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -1,9 +1,7 @@
-package main
+package lox
 
 import (
-	"fmt"
 	"math"
-	"os"
 	"strconv"
 )
 
@@ -28,11 +26,6 @@ const (
 	FN_TYPE_INITIALIZER
 )
 
-const (
-	MAX_NUM_OF_LOCAL_VARS int = math.MaxUint8
-	MAX_NUM_OF_UP_VALS    int = math.MaxUint8
-)
-
 type ParseFn func(*Parser, bool)
 
 type ParseRule struct {
@@ -50,6 +43,14 @@ type Parser struct {
 	panicMode    bool
 	compiler     *Compiler
 	currentClass *ClassCompiler
+	config       Config
+	diagnostics  []Diagnostic
+	operandStart codeMark       // where the left operand of the infix operator being compiled starts
+	replMode     bool           // echo a trailing top-level expression instead of requiring ';'
+	scriptSource string         // the source as a script, with the expression replMode echoes printed instead
+	globals      []string       // name of each global slot, shared by every function of the program
+	globalSlots  map[string]int // slot of each name in globals
+	caches       int            // inline cache slots handed out so far
 }
 
 type Local struct {
@@ -59,18 +60,42 @@ type Local struct {
 }
 
 type UpValue struct {
-	index   byte
+	index   int
 	isLocal bool
 }
 
 type Compiler struct {
-	locals     [MAX_NUM_OF_LOCAL_VARS]Local // all locals that are in scope during each point in the compilation process
-	scopeDepth int                          // the number of blocks surrounding the current bit of code we’re compiling
-	localCount int
-	upValues   [MAX_NUM_OF_UP_VALS]UpValue
-	function   *LoxFunction
-	fnType     int
-	enclosing  *Compiler
+	locals       []Local // all locals that are in scope during each point in the compilation process
+	scopeDepth   int     // the number of blocks surrounding the current bit of code we’re compiling
+	localCount   int
+	upValues     []UpValue
+	function     *LoxFunction
+	fnType       int
+	enclosing    *Compiler
+	constants    map[constantKey]int // index of each number and string already in the chunk
+	longJumps    bool                // emit every forward jump in its 32-bit form
+	jumpOverflow bool                // a 16-bit forward jump didn't fit, recompile with longJumps
+}
+
+// parserState is where the parser stood before a function's body, so the
+// function can be compiled again from there.
+type parserState struct {
+	scanner     Scanner
+	current     Token
+	previous    Token
+	hadError    bool
+	panicMode   bool
+	diagnostics int
+	globals     int
+	caches      int
+}
+
+// constantKey identifies a constant by type and value so equal literals and
+// names share one slot of the constant pool.
+type constantKey struct {
+	isString bool
+	number   uint64 // bit pattern, so 0 and -0 stay distinct
+	str      string
 }
 
 type ClassCompiler struct {
@@ -94,16 +119,83 @@ func (parser *Parser) currentChunkSize() int {
 	return len(parser.currentChunk().bcodes)
 }
 
-func (parser *Parser) makeConstant(value Value) byte {
+// constantKeyOf returns the key of a number or string constant. Other
+// constants are not shared.
+func constantKeyOf(value Value) (constantKey, bool) {
+	if n, ok := value.GetFloat(); ok {
+		return constantKey{number: math.Float64bits(n)}, true
+	} else if s, ok := value.GetString(); ok {
+		return constantKey{isString: true, str: s}, true
+	}
+	return constantKey{}, false
+}
+
+func (parser *Parser) makeConstant(value Value) int {
+	key, ok := constantKeyOf(value)
+	if !ok {
+		return parser.addConstant(value)
+	}
+	if offset, ok := parser.compiler.constants[key]; ok {
+		return offset
+	}
+	offset := parser.addConstant(value)
+	parser.compiler.constants[key] = offset
+	return offset
+}
+
+func (parser *Parser) addConstant(value Value) int {
 	offset := AddConstant(parser.currentChunk(), value)
-	if offset > 255 {
+	if offset >= MAX_CONSTANTS {
 		parser.errorAtPrevious("Too many constants in one chunk.")
+		return 0
+	}
+	return offset
+}
+
+// emitIndexOp emits an instruction that takes a constant, local slot or
+// upvalue index, switching to its 24-bit form when the index doesn't fit in
+// a byte.
+func (parser *Parser) emitIndexOp(op byte, index int) {
+	if index <= math.MaxUint8 {
+		parser.emitBytes(op, byte(index))
+		return
+	}
+	parser.emitByte(longOps[op])
+	parser.emitByte(byte(index >> 16 & 0xFF))
+	parser.emitByte(byte(index >> 8 & 0xFF))
+	parser.emitByte(byte(index & 0xFF))
+}
+
+// emitCachedOp emits a property or method instruction with its name
+// constant and an inline cache slot of its own, in the long form if either
+// doesn't fit the short one.
+func (parser *Parser) emitCachedOp(op byte, name int) {
+	cache := parser.caches
+	parser.caches++
+	if name <= math.MaxUint8 && cache <= math.MaxUint16 {
+		parser.emitBytes(op, byte(name))
+		parser.emitBytes(byte(cache>>8&0xFF), byte(cache&0xFF))
+		return
+	}
+	parser.emitByte(longOps[op])
+	for _, index := range []int{name, cache} {
+		parser.emitByte(byte(index >> 16 & 0xFF))
+		parser.emitByte(byte(index >> 8 & 0xFF))
+		parser.emitByte(byte(index & 0xFF))
 	}
-	return byte(offset)
 }
 
 func (parser *Parser) emitByte(b byte) {
-	WriteChunk(parser.currentChunk(), b, parser.previous.line)
+	WriteChunk(parser.currentChunk(), b, parser.previous.pos)
+}
+
+// emitOperator emits the instructions of an operator positioned at the
+// operator itself rather than at the end of its last operand, so runtime
+// errors point at the operation that failed.
+func (parser *Parser) emitOperator(operator *Token, bs ...byte) {
+	for _, b := range bs {
+		WriteChunk(parser.currentChunk(), b, operator.pos)
+	}
 }
 
 func (parser *Parser) emitBytes(b1 byte, b2 byte) {
@@ -112,7 +204,7 @@ func (parser *Parser) emitBytes(b1 byte, b2 byte) {
 }
 
 func (parser *Parser) emitConstant(value Value) {
-	parser.emitBytes(OP_CONSTANT, parser.makeConstant(value))
+	parser.emitIndexOp(OP_CONSTANT, parser.makeConstant(value))
 }
 
 func (parser *Parser) emitReturn() {
@@ -124,22 +216,39 @@ func (parser *Parser) emitReturn() {
 	parser.emitByte(OP_RETURN)
 }
 
-func (parser *Parser) errorAt(token *Token, message string) {
+func (parser *Parser) save() parserState {
+	return parserState{parser.scanner, parser.current, parser.previous, parser.hadError, parser.panicMode, len(parser.diagnostics), len(parser.globals), parser.caches}
+}
+
+func (parser *Parser) restore(state parserState) {
+	parser.scanner = state.scanner
+	parser.current = state.current
+	parser.previous = state.previous
+	parser.hadError = state.hadError
+	parser.panicMode = state.panicMode
+	parser.diagnostics = parser.diagnostics[:state.diagnostics]
+	for _, name := range parser.globals[state.globals:] {
+		delete(parser.globalSlots, name)
+	}
+	parser.globals = parser.globals[:state.globals]
+	parser.caches = state.caches
+}
+
+func (parser *Parser) errorAt(token *Token, message string, notes ...Note) {
 	if parser.panicMode {
 		return
 	}
 	parser.panicMode = true
-	fmt.Fprintf(os.Stderr, "[line %d] Error", token.line)
+	diagnostic := Diagnostic{Position: token.pos, Message: message, Notes: notes}
 	switch token.token_type {
 	case TOKEN_EOF:
-		fmt.Fprintf(os.Stderr, " at end")
+		diagnostic.AtEnd = true
 	case TOKEN_ERROR:
 		// Nothing.
 	default:
-		fmt.Fprintf(os.Stderr, " at '%s'", token.lexeme)
+		diagnostic.Token = token.lexeme
 	}
-
-	fmt.Fprintf(os.Stderr, ": %s\n", message)
+	parser.diagnostics = append(parser.diagnostics, diagnostic)
 	parser.hadError = true
 }
 
@@ -191,11 +300,13 @@ func (parser *Parser) parsePrecedence(precedence byte) {
 	}
 
 	canAssign := precedence <= PREC_ASSIGNMENT
+	start := parser.mark()
 	prefix(parser, canAssign)
 
 	for precedence <= parser.getRule(parser.current.token_type).precedence {
 		parser.advance()
 		infix := parser.getRule(parser.previous.token_type).infix
+		parser.operandStart = start
 		infix(parser, canAssign)
 	}
 
@@ -222,46 +333,79 @@ func (parser *Parser) grouping(canAssign bool) {
 	parser.consume(TOKEN_RIGHT_PAREN, "Expect ')' after expression.")
 }
 
+// unary and binary fold operators on literals into their value, see
+// foldUnary and foldBinary.
 func (parser *Parser) unary(canAssign bool) {
-	operator_type := parser.previous.token_type
+	operator := parser.previous
+	start := parser.mark()
 	parser.parsePrecedence(PREC_UNARY)
-	switch operator_type {
+	var op byte
+	switch operator.token_type {
 	case TOKEN_MINUS:
-		parser.emitByte(OP_NEGATE)
+		op = OP_NEGATE
 	case TOKEN_BANG:
-		parser.emitByte(OP_NOT)
+		op = OP_NOT
+	}
+	if !parser.foldUnary(start, op) {
+		parser.emitOperator(&operator, op)
 	}
 }
 
 func (parser *Parser) binary(canAssign bool) {
-	operator_type := parser.previous.token_type
-	rule := parser.getRule(operator_type)
+	left := parser.operandStart
+	operator := parser.previous
+	rule := parser.getRule(operator.token_type)
+	right := parser.mark()
 	parser.parsePrecedence(rule.precedence + 1)
-	switch operator_type {
+	var ops []byte
+	switch operator.token_type {
 	case TOKEN_BANG_EQUAL:
-		parser.emitBytes(OP_EQUAL, OP_NOT)
+		ops = []byte{OP_EQUAL, OP_NOT}
 	case TOKEN_EQUAL_EQUAL:
-		parser.emitByte(OP_EQUAL)
+		ops = []byte{OP_EQUAL}
 	case TOKEN_GREATER:
-		parser.emitByte(OP_GREATER)
+		ops = []byte{OP_GREATER}
 	case TOKEN_GREATER_EQUAL:
-		parser.emitBytes(OP_LESS, OP_NOT)
+		ops = []byte{OP_LESS, OP_NOT}
 	case TOKEN_LESS:
-		parser.emitByte(OP_LESS)
+		ops = []byte{OP_LESS}
 	case TOKEN_LESS_EQUAL:
-		parser.emitBytes(OP_GREATER, OP_NOT)
+		ops = []byte{OP_GREATER, OP_NOT}
 	case TOKEN_PLUS:
-		parser.emitByte(OP_ADD)
+		ops = []byte{OP_ADD}
 	case TOKEN_MINUS:
-		parser.emitByte(OP_SUBTRACT)
+		ops = []byte{OP_SUBTRACT}
 	case TOKEN_STAR:
-		parser.emitByte(OP_MULTIPLY)
+		ops = []byte{OP_MULTIPLY}
 	case TOKEN_SLASH:
-		parser.emitByte(OP_DIVIDE)
+		ops = []byte{OP_DIVIDE}
+	}
+	if !parser.foldBinary(left, right, ops...) {
+		parser.emitOperator(&operator, ops...)
+	}
+}
+
+// constantCondition compiles the right operand of and or or when the left
+// one, emitted since left, is a literal. The result is the left operand if
+// it decides the outcome, as in `false and x`, and the right operand is
+// checked but dropped. Otherwise the result is the right operand alone.
+func (parser *Parser) constantCondition(left codeMark, precedence byte, decides bool) {
+	if decides {
+		right := parser.mark()
+		parser.parsePrecedence(precedence)
+		parser.discard(right)
+		return
 	}
+	parser.discard(left)
+	parser.parsePrecedence(precedence)
 }
 
 func (parser *Parser) andRule(canAssign bool) {
+	start := parser.operandStart
+	if left, ok := parser.literalSince(start); ok {
+		parser.constantCondition(start, PREC_AND, isfalsey(left))
+		return
+	}
 	endJump := parser.emitJump(OP_JUMP_IF_FALSE)
 	parser.emitByte(OP_POP)
 	parser.parsePrecedence(PREC_AND)
@@ -269,6 +413,11 @@ func (parser *Parser) andRule(canAssign bool) {
 }
 
 func (parser *Parser) orRule(canAssign bool) {
+	start := parser.operandStart
+	if left, ok := parser.literalSince(start); ok {
+		parser.constantCondition(start, PREC_OR, !isfalsey(left))
+		return
+	}
 	elseJump := parser.emitJump(OP_JUMP_IF_FALSE)
 	endJump := parser.emitJump(OP_JUMP)
 	parser.patchJump(elseJump)
@@ -292,40 +441,39 @@ func (parser *Parser) stringLiteral(canAssign bool) {
 	parser.emitConstant(StringVal(parser.previous.lexeme[1 : len(parser.previous.lexeme)-1]))
 }
 
-func (parser *Parser) resolveLocal(compiler *Compiler, name *Token) (byte, bool) {
+func (parser *Parser) resolveLocal(compiler *Compiler, name *Token) (int, bool) {
 	for i := compiler.localCount - 1; i >= 0; i-- {
 		local := &compiler.locals[i]
 		if identifiersEqual(name, &local.name) {
 			if local.depth == -1 {
 				parser.errorAtPrevious("Can't read local variable in its own initializer.")
 			}
-			return byte(i), true
+			return i, true
 		}
 	}
 	return 0, false
 }
 
-func (parser *Parser) addUpvalue(compiler *Compiler, index byte, isLocal bool) (byte, bool) {
+func (parser *Parser) addUpvalue(compiler *Compiler, index int, isLocal bool) (int, bool) {
 	for i := 0; i < compiler.function.upValueCount; i++ {
 		if compiler.upValues[i].index == index && compiler.upValues[i].isLocal == isLocal {
-			return byte(i), true
+			return i, true
 		}
 	}
 	upValueCount := compiler.function.upValueCount
 
-	if upValueCount >= MAX_NUM_OF_UP_VALS {
+	if upValueCount >= MAX_UPVALUES {
 		parser.errorAtPrevious("Too many closure variables in function.")
 		return 0, false
 	}
-	compiler.upValues[upValueCount].isLocal = isLocal
-	compiler.upValues[upValueCount].index = index
+	compiler.upValues = append(compiler.upValues, UpValue{index: index, isLocal: isLocal})
 	compiler.function.upValueCount++
-	return byte(upValueCount), true
+	return upValueCount, true
 }
 
-func (parser *Parser) resolveUpvalue(compiler *Compiler, name *Token) (byte, bool) {
-	var local byte
-	var upvalue byte
+func (parser *Parser) resolveUpvalue(compiler *Compiler, name *Token) (int, bool) {
+	var local int
+	var upvalue int
 	var ok bool
 	if compiler.enclosing == nil {
 		return 0, false
@@ -344,8 +492,8 @@ func (parser *Parser) resolveUpvalue(compiler *Compiler, name *Token) (byte, boo
 
 func (parser *Parser) namedVariable(name *Token, canAssign bool) {
 	var getOP, setOP byte
-	var arg byte = 0
-	var ok bool = false
+	var arg int
+	var ok bool
 
 	if arg, ok = parser.resolveLocal(parser.compiler, name); ok {
 		getOP = OP_GET_LOCAL
@@ -354,17 +502,17 @@ func (parser *Parser) namedVariable(name *Token, canAssign bool) {
 		getOP = OP_GET_UPVALUE
 		setOP = OP_SET_UPVALUE
 	} else {
-		arg = parser.identifierConstant(name)
+		arg = parser.globalSlot(name)
 		getOP = OP_GET_GLOBAL
 		setOP = OP_SET_GLOBAL
 	}
 
+	op := getOP
 	if canAssign && parser.match(TOKEN_EQUAL) {
 		parser.expression()
-		parser.emitBytes(setOP, arg)
-	} else {
-		parser.emitBytes(getOP, arg)
+		op = setOP
 	}
+	parser.emitIndexOp(op, arg)
 }
 
 func (parser *Parser) variable(canAssign bool) {
@@ -385,7 +533,7 @@ func (parser *Parser) argumentList() byte {
 			}
 		}
 	}
-	parser.consume(TOKEN_RIGHT_PAREN, "Expect ')' after argument list.")
+	parser.consume(TOKEN_RIGHT_PAREN, "Expect ')' after arguments.")
 	return argCount
 }
 
@@ -399,13 +547,13 @@ func (parser *Parser) dot(canAssign bool) {
 	name := parser.identifierConstant(&parser.previous)
 	if canAssign && parser.match(TOKEN_EQUAL) {
 		parser.expression()
-		parser.emitBytes(OP_SET_PROPERTY, name)
+		parser.emitIndexOp(OP_SET_PROPERTY, name)
 	} else if parser.match(TOKEN_LEFT_PAREN) {
 		argCount := parser.argumentList()
-		parser.emitBytes(OP_INVOKE, name)
+		parser.emitCachedOp(OP_INVOKE, name)
 		parser.emitByte(argCount)
 	} else {
-		parser.emitBytes(OP_GET_PROPERTY, name)
+		parser.emitCachedOp(OP_GET_PROPERTY, name)
 	}
 }
 
@@ -416,8 +564,16 @@ func (parser *Parser) printStatement() {
 }
 
 func (parser *Parser) expressionStatement() {
+	start := parser.current.pos.Offset
 	parser.expression()
-	parser.consume(TOKEN_SEMICOLON, "Expect '; after expression.")
+	if parser.replMode && parser.compiler.fnType == FN_TYPE_SCRIPT && parser.compiler.scopeDepth == 0 && parser.check(TOKEN_EOF) {
+		source := parser.scanner.source
+		end := parser.previous.pos.Offset + len(parser.previous.lexeme)
+		parser.scriptSource = source[:start] + "print " + source[start:end] + ";" + source[end:]
+		parser.emitByte(OP_PRINT)
+		return
+	}
+	parser.consume(TOKEN_SEMICOLON, "Expect ';' after expression.")
 	parser.emitByte(OP_POP)
 }
 
@@ -444,22 +600,47 @@ func (parser *Parser) endScope() {
 	}
 }
 
+// jumpWidth is the size of the forward jump operands the current function
+// is compiled with.
+func (parser *Parser) jumpWidth() int {
+	if parser.compiler.longJumps {
+		return 4
+	}
+	return 2
+}
+
 func (parser *Parser) emitJump(op byte) int {
+	width := parser.jumpWidth()
+	if width == 4 {
+		op = longOps[op]
+	}
 	parser.emitByte(op)
-	parser.emitByte(0xFF)
-	parser.emitByte(0xFF)
-	return parser.currentChunkSize() - 2
+	for range width {
+		parser.emitByte(0xFF)
+	}
+	return parser.currentChunkSize() - width
 }
 
+// patchJump fills in the offset of the jump whose operand starts at offset.
+// A 16-bit jump that doesn't fit marks the function for recompiling with
+// 32-bit jumps, see function.
 func (parser *Parser) patchJump(offset int) {
-	jump := parser.currentChunkSize() - offset - 2
-	if jump > math.MaxUint16 {
+	width := parser.jumpWidth()
+	jump := parser.currentChunkSize() - offset - width
+	if width == 2 && jump > math.MaxUint16 {
+		parser.compiler.jumpOverflow = true
+		return
+	}
+	if jump > math.MaxUint32 {
 		parser.errorAtPrevious("Too much code to jump over.")
+		return
 	}
 	// Big-endian
 	chunk := parser.currentChunk()
-	chunk.bcodes[offset] = byte((jump >> 8) & 0xFF)
-	chunk.bcodes[offset+1] = byte(jump & 0xFF)
+	for i := width - 1; i >= 0; i-- {
+		chunk.bcodes[offset+i] = byte(jump & 0xFF)
+		jump >>= 8
+	}
 }
 
 /*
@@ -475,8 +656,18 @@ statements(after if statement)
 */
 func (parser *Parser) ifStatement() {
 	parser.consume(TOKEN_LEFT_PAREN, "Expect '(' after 'if'.")
+	condition := parser.mark()
 	parser.expression()
 	parser.consume(TOKEN_RIGHT_PAREN, "Expect ')' after condition.")
+	if value, ok := parser.literalSince(condition); ok {
+		// only the branch that runs is kept, the other is still checked
+		parser.discard(condition)
+		parser.deadStatement(isfalsey(value))
+		if parser.match(TOKEN_ELSE) {
+			parser.deadStatement(!isfalsey(value))
+		}
+		return
+	}
 	elseJump := parser.emitJump(OP_JUMP_IF_FALSE)
 	parser.emitByte(OP_POP)
 	parser.statement()
@@ -489,21 +680,51 @@ func (parser *Parser) ifStatement() {
 	parser.patchJump(endJump)
 }
 
+// emitLoop jumps back to loopStart, using OP_LOOP_LONG when the body is
+// larger than 64KiB.
 func (parser *Parser) emitLoop(loopStart int) {
-	parser.emitByte(OP_LOOP)
-	offset := parser.currentChunkSize() - loopStart + 2
-	if offset > math.MaxUint16 {
+	offset := parser.currentChunkSize() - loopStart + 3
+	if offset <= math.MaxUint16 {
+		parser.emitByte(OP_LOOP)
+		parser.emitByte(byte(offset >> 8 & 0xFF))
+		parser.emitByte(byte(offset & 0xFF))
+		return
+	}
+	offset += 2
+	if offset > math.MaxUint32 {
 		parser.errorAtPrevious("Loop body too large.")
 	}
+	parser.emitByte(OP_LOOP_LONG)
+	parser.emitByte(byte(offset >> 24 & 0xFF))
+	parser.emitByte(byte(offset >> 16 & 0xFF))
 	parser.emitByte(byte(offset >> 8 & 0xFF))
 	parser.emitByte(byte(offset & 0xFF))
 }
 
+// deadStatement compiles a statement, dropping its code if it never runs.
+func (parser *Parser) deadStatement(dead bool) {
+	start := parser.mark()
+	parser.statement()
+	if dead {
+		parser.discard(start)
+	}
+}
+
 func (parser *Parser) whileStatement() {
 	loopStart := parser.currentChunkSize()
+	condition := parser.mark()
 	parser.consume(TOKEN_LEFT_PAREN, "Expect '(' after 'while'.")
 	parser.expression()
 	parser.consume(TOKEN_RIGHT_PAREN, "Expect ')' after condition.")
+	if value, ok := parser.literalSince(condition); ok {
+		// while (false) drops the loop, while (true) needs no test
+		parser.discard(condition)
+		parser.deadStatement(isfalsey(value))
+		if !isfalsey(value) {
+			parser.emitLoop(loopStart)
+		}
+		return
+	}
 
 	exitJump := parser.emitJump(OP_JUMP_IF_FALSE)
 	parser.emitByte(OP_POP)
@@ -532,7 +753,7 @@ func (parser *Parser) forStatement() {
 
 	if !parser.match(TOKEN_SEMICOLON) {
 		parser.expression()
-		parser.consume(TOKEN_SEMICOLON, "Expect ';' after for loop condition.")
+		parser.consume(TOKEN_SEMICOLON, "Expect ';' after loop condition.")
 		exitJump = parser.emitJump(OP_JUMP_IF_FALSE)
 		parser.emitByte(OP_POP)
 	}
@@ -542,7 +763,7 @@ func (parser *Parser) forStatement() {
 		loopIncrement := parser.currentChunkSize()
 		parser.expression()
 		parser.emitByte(OP_POP)
-		parser.consume(TOKEN_RIGHT_PAREN, "Expect ')' after for loop clauses.")
+		parser.consume(TOKEN_RIGHT_PAREN, "Expect ')' after for clauses.")
 		parser.emitLoop(loopStart)
 		parser.patchJump(bodyJump)
 		loopStart = loopIncrement
@@ -595,20 +816,31 @@ func (parser *Parser) statement() {
 	}
 }
 
-func (parser *Parser) identifierConstant(token *Token) byte {
-	return parser.makeConstant(StringVal(token.lexeme))
+func (parser *Parser) identifierConstant(token *Token) int {
+	return parser.makeConstant(StringVal(Intern(token.lexeme)))
+}
+
+// globalSlot returns the slot of the global variable named by token, giving
+// it the next free one the first time the name is used. The VM links these
+// slots to its own global table when it runs the program.
+func (parser *Parser) globalSlot(token *Token) int {
+	if slot, ok := parser.globalSlots[token.lexeme]; ok {
+		return slot
+	}
+	parser.globalSlots[token.lexeme] = len(parser.globals)
+	parser.globals = append(parser.globals, token.lexeme)
+	return len(parser.globals) - 1
 }
 
 func (parser *Parser) addLocal(name *Token) {
-	if parser.compiler.localCount >= MAX_NUM_OF_LOCAL_VARS {
+	compiler := parser.compiler
+	if compiler.localCount >= MAX_LOCALS {
 		parser.errorAtPrevious("Too many local variables in function.")
 		return
 	}
-	local := &parser.compiler.locals[parser.compiler.localCount]
-	parser.compiler.localCount++
-	local.name = *name
-	local.depth = -1
-	local.isCaptured = false
+	// locals past localCount went out of scope and are overwritten
+	compiler.locals = append(compiler.locals[:compiler.localCount], Local{name: *name, depth: -1})
+	compiler.localCount++
 }
 
 func (parser *Parser) declareVariable() {
@@ -617,27 +849,27 @@ func (parser *Parser) declareVariable() {
 	}
 
 	// check local var duplicate declare
-	for i := parser.compiler.localCount - 1; i >= 0; i++ {
+	for i := parser.compiler.localCount - 1; i >= 0; i-- {
 		local := &parser.compiler.locals[i]
 		if local.depth != -1 && local.depth < parser.compiler.scopeDepth {
 			break
 		}
 		if identifiersEqual(&local.name, &parser.previous) {
-			parser.errorAtPrevious("Already a variable with this name in this scope.")
+			parser.errorAt(&parser.previous, "Already a variable with this name in this scope.", Note{local.name.pos, "variable declared here"})
 		}
 	}
 
 	parser.addLocal(&parser.previous)
 }
 
-func (parser *Parser) parseVariable(error_msg string) byte {
+func (parser *Parser) parseVariable(error_msg string) int {
 	parser.consume(TOKEN_IDENTIFIER, error_msg)
 
 	parser.declareVariable()
 	if parser.compiler.scopeDepth > 0 {
 		return 0
 	}
-	return parser.identifierConstant(&parser.previous)
+	return parser.globalSlot(&parser.previous)
 }
 
 func (parser *Parser) markInitialized() {
@@ -647,16 +879,16 @@ func (parser *Parser) markInitialized() {
 	parser.compiler.locals[parser.compiler.localCount-1].depth = parser.compiler.scopeDepth
 }
 
-func (parser *Parser) defineVariable(global byte) {
+func (parser *Parser) defineVariable(global int) {
 	if parser.compiler.scopeDepth > 0 {
 		parser.markInitialized()
 		return
 	}
-	parser.emitBytes(OP_DEFINE_GLOBAL, global)
+	parser.emitIndexOp(OP_DEFINE_GLOBAL, global)
 }
 
 func (parser *Parser) varDeclaration() {
-	var global byte = parser.parseVariable("Expect variable name.")
+	var global int = parser.parseVariable("Expect variable name.")
 	if parser.match(TOKEN_EQUAL) {
 		parser.expression()
 	} else {
@@ -666,16 +898,47 @@ func (parser *Parser) varDeclaration() {
 	parser.defineVariable(global)
 }
 
+// function compiles a function body and emits the closure that creates it.
+// Forward jumps start out 16 bits wide; if one of them turns out too short,
+// the body is compiled again from the same point with 32-bit jumps.
 func (parser *Parser) function(fnType int) {
+	state := parser.save()
 	var compiler Compiler
-	parser.initCompiler(&compiler, fnType)
+	function := parser.functionBody(&compiler, fnType, false)
+	if compiler.jumpOverflow {
+		parser.restore(state)
+		compiler = Compiler{}
+		function = parser.functionBody(&compiler, fnType, true)
+	}
+	parser.emitIndexOp(OP_CLOSURE, parser.makeConstant(FunctionVal(function))) // add function obj to bcode
+
+	for _, upValue := range compiler.upValues {
+		var flags byte = 0
+		if upValue.isLocal {
+			flags |= UPVALUE_LOCAL
+		}
+		if upValue.index > math.MaxUint8 {
+			parser.emitByte(flags | UPVALUE_WIDE)
+			parser.emitByte(byte(upValue.index >> 16 & 0xFF))
+			parser.emitByte(byte(upValue.index >> 8 & 0xFF))
+			parser.emitByte(byte(upValue.index & 0xFF))
+		} else {
+			parser.emitByte(flags)
+			parser.emitByte(byte(upValue.index))
+		}
+	}
+}
+
+func (parser *Parser) functionBody(compiler *Compiler, fnType int, longJumps bool) *LoxFunction {
+	parser.initCompiler(compiler, fnType)
+	compiler.longJumps = longJumps
 	parser.beginScope()
 	parser.consume(TOKEN_LEFT_PAREN, "Expect '(' after function name.")
 	if !parser.check(TOKEN_RIGHT_PAREN) {
 		for {
 			parser.compiler.function.arity++
 			if parser.compiler.function.arity > 255 {
-				parser.errorAtCurrent("Can't have more than 255 paramenters.")
+				parser.errorAtCurrent("Can't have more than 255 parameters.")
 			}
 			constant := parser.parseVariable("Expect parameter name.")
 			parser.defineVariable(constant)
@@ -684,21 +947,10 @@ func (parser *Parser) function(fnType int) {
 			}
 		}
 	}
-	parser.consume(TOKEN_RIGHT_PAREN, "Expect ')' after paramenters.")
+	parser.consume(TOKEN_RIGHT_PAREN, "Expect ')' after parameters.")
 	parser.consume(TOKEN_LEFT_BRACE, "Expect '{' before function body.")
 	parser.block()
-
-	function := parser.endCompiler()
-	parser.emitBytes(OP_CLOSURE, parser.makeConstant(FunctionVal(function))) // add function obj to bcode
-
-	for i := 0; i < function.upValueCount; i++ {
-		var islocal byte = 0
-		if compiler.upValues[i].isLocal {
-			islocal = 1
-		}
-		parser.emitByte(islocal)
-		parser.emitByte(compiler.upValues[i].index)
-	}
+	return parser.endCompiler()
 }
 
 func (parser *Parser) functionDeclaration() {
@@ -716,7 +968,7 @@ func (parser *Parser) method() {
 		fnType = FN_TYPE_INITIALIZER
 	}
 	parser.function(fnType)
-	parser.emitBytes(OP_METHOD, constant)
+	parser.emitIndexOp(OP_METHOD, constant)
 }
 
 func (parser *Parser) syntheticToken(name string) Token {
@@ -729,8 +981,12 @@ func (parser *Parser) classDeclaration() {
 	classToken := parser.previous
 	nameConstant := parser.identifierConstant(&parser.previous)
 	parser.declareVariable()
-	parser.emitBytes(OP_CLASS, nameConstant) // 1.push class value into stack
-	parser.defineVariable(nameConstant)      // 2.define class, class value at top stack
+	global := 0
+	if parser.compiler.scopeDepth == 0 {
+		global = parser.globalSlot(&classToken)
+	}
+	parser.emitIndexOp(OP_CLASS, nameConstant) // 1.push class value into stack
+	parser.defineVariable(global)              // 2.define class, class value at top stack
 	classCompiler := ClassCompiler{enclosing: parser.currentClass, hasSuperclass: false}
 	parser.currentClass = &classCompiler
 	if parser.match(TOKEN_LESS) {
@@ -802,10 +1058,8 @@ func (parser *Parser) thisExpr(canAssign bool) {
 func (parser *Parser) superExpr(canAssign bool) {
 	if parser.currentClass == nil {
 		parser.errorAtPrevious("Can't use 'super' outside of a class.")
-	}
-	if !parser.currentClass.hasSuperclass {
+	} else if !parser.currentClass.hasSuperclass {
 		parser.errorAtPrevious("Can't use 'super' in a class with no superclass.")
-
 	}
 	parser.consume(TOKEN_DOT, "Expect '.' after 'super'.")
 	parser.consume(TOKEN_IDENTIFIER, "Expect superclass method name.")
@@ -817,11 +1071,11 @@ func (parser *Parser) superExpr(canAssign bool) {
 	if parser.match(TOKEN_LEFT_PAREN) {
 		argCount := parser.argumentList()
 		parser.namedVariable(&superToken, false)
-		parser.emitBytes(OP_INVOKE_SUPER, name)
+		parser.emitCachedOp(OP_INVOKE_SUPER, name)
 		parser.emitByte(argCount)
 	} else {
 		parser.namedVariable(&superToken, false)
-		parser.emitBytes(OP_GET_SUPER, name)
+		parser.emitCachedOp(OP_GET_SUPER, name)
 	}
 }
 
@@ -873,22 +1127,23 @@ func (parser *Parser) initParseRule() {
 func (parser *Parser) initCompiler(compiler *Compiler, fnType int) {
 	compiler.fnType = fnType
 	compiler.function = NewFunction()
+	compiler.function.chunk.file = parser.config.File
 	compiler.scopeDepth = 0
 	compiler.localCount = 0
+	compiler.constants = make(map[constantKey]int)
 
 	if fnType != FN_TYPE_SCRIPT {
 		compiler.function.name = parser.previous.lexeme
 	}
 
-	local := &compiler.locals[compiler.localCount]
-	compiler.localCount++
-	local.depth = 0
-	local.isCaptured = false
+	local := Local{depth: 0}
 	if fnType == FN_TYPE_FUNCTION {
 		local.name.lexeme = ""
 	} else {
 		local.name.lexeme = "this"
 	}
+	compiler.locals = append(compiler.locals, local)
+	compiler.localCount++
 
 	compiler.enclosing = parser.compiler
 	parser.compiler = compiler
@@ -897,24 +1152,59 @@ func (parser *Parser) initCompiler(compiler *Compiler, fnType int) {
 func (parser *Parser) endCompiler() *LoxFunction {
 	parser.emitReturn()
 	function := parser.compiler.function
-	if !parser.hadError {
-		DisassembleChunk(parser.currentChunk(), NormalizedFuncName(function.name))
+	function.chunk.globals = parser.globals
+	function.chunk.caches = parser.caches
+	if !parser.hadError && !parser.compiler.jumpOverflow && parser.config.Disassemble {
+		DisassembleChunk(parser.config.Stdout, parser.currentChunk(), NormalizedFuncName(function.name))
 	}
 	parser.compiler = parser.compiler.enclosing
 	return function
 }
 
-func Compile(source string) (bool, *LoxFunction) {
+func Compile(source string, config Config) (*LoxFunction, error) {
+	function, _, err := compile(source, config, false)
+	return function, err
+}
+
+// CompileREPL compiles one REPL entry. It differs from Compile only in that a
+// bare expression at the end of the input, such as `1 + 2`, is printed. It
+// also returns the entry as a script that does the same outside the REPL,
+// with that expression turned into a print statement.
+func CompileREPL(source string, config Config) (*LoxFunction, string, error) {
+	return compile(source, config, true)
+}
+
+func compile(source string, config Config, replMode bool) (*LoxFunction, string, error) {
 	var compiler Compiler
-	parser := Parser{scanner: Scanner{1, 0, 0, source}, hadError: false, panicMode: false, currentClass: nil}
+	parser := Parser{scanner: NewScanner(source), hadError: false, panicMode: false, currentClass: nil, config: config.withDefaults(), replMode: replMode, scriptSource: source, globalSlots: make(map[string]int)}
+	parser.scanner.file = config.File
 	parser.advance()
-
 	parser.initParseRule()
-	parser.initCompiler(&compiler, FN_TYPE_SCRIPT)
+
+	state := parser.save()
+	function := parser.script(&compiler, false)
+	if compiler.jumpOverflow {
+		parser.restore(state)
+		compiler = Compiler{}
+		function = parser.script(&compiler, true)
+	}
+
+	if parser.hadError {
+		return nil, "", &CompileError{Diagnostics: parser.diagnostics}
+	}
+	// compute the stack depth each function needs so deep recursion or a
+	// huge frame reports a stack overflow instead of crashing the VM
+	if err := Verify(function); err != nil {
+		return nil, "", err
+	}
+	return function, parser.scriptSource, nil
+}
+
+func (parser *Parser) script(compiler *Compiler, longJumps bool) *LoxFunction {
+	parser.initCompiler(compiler, FN_TYPE_SCRIPT)
+	compiler.longJumps = longJumps
 	for !parser.match(TOKEN_EOF) {
 		parser.declaration()
 	}
-	function := parser.endCompiler()
-
-	return !parser.hadError, function
+	return parser.endCompiler()
 }
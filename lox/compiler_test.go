@@ -0,0 +1,210 @@
+package lox
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestConstantsAreDeduplicated(t *testing.T) {
+	function, err := Compile(`
+var count = 1;
+count = count + 1;
+print count + count * 1;
+var s = "a";
+print s + "a";
+print count == 1.0;
+`, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, constant := range function.chunk.constants {
+		got = append(got, constant.TypeName()+" "+constant.String())
+	}
+	want := []string{"number 1", "string a"}
+	if !slices.Equal(got, want) {
+		t.Errorf("constants = %q, want %q", got, want)
+	}
+	if want := []string{"count", "s"}; !slices.Equal(function.chunk.globals, want) {
+		t.Errorf("globals = %q, want %q", function.chunk.globals, want)
+	}
+}
+
+func TestWideLocalsUpvaluesAndJumps(t *testing.T) {
+	var src strings.Builder
+	src.WriteString("fun outer() {\n")
+	for i := range 300 {
+		fmt.Fprintf(&src, "var v%d = %d;\n", i, i)
+	}
+	src.WriteString("fun inner() { return v0 + v299; }\nv299 = 1000;\nreturn inner;\n}\n")
+	src.WriteString("var x = 0;\nif (outer()() == 1000) {\n")
+	src.WriteString(strings.Repeat("x = x + 1;\n", 20000))
+	src.WriteString("}\nprint x;\n")
+
+	function, err := Compile(src.String(), Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := MarshalFunction(function)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UnmarshalFunction(data); err != nil {
+		t.Fatal(err)
+	}
+	if got := runScript(src.String()); got != "20000\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestLocalRecursiveFunction(t *testing.T) {
+	src := `{ fun f(n) { if (n > 0) return f(n - 1); return "done"; } print f(3); }`
+	if _, err := Compile(src, Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := runScript(src); got != "done\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	_, err := Compile("var a = 1;\nvar b = ;\n", Config{File: "test.lox"})
+	if got, want := err.Error(), "test.lox:2:9: Error at ';': Expect expression."; got != want {
+		t.Errorf("compile error = %q, want %q", got, want)
+	}
+
+	function, err := Compile("fun f() {\n  return 1 + nil;\n}\nf();\n", Config{File: "test.lox"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewVM(Config{}).Interpret(function)
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("got %v, want a runtime error", err)
+	}
+	want := []string{"test.lox:2:12 in f()", "test.lox:4:3 in script"}
+	var got []string
+	for _, frame := range runtimeErr.Trace {
+		got = append(got, frame.String())
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("trace = %q, want %q", got, want)
+	}
+}
+
+func TestConstantFolding(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"var seconds = 60 * 60 * 24;", `
+0000 OP_CONSTANT         0 '86400'
+0002 OP_DEFINE_GLOBAL    0 'seconds'`},
+		{"print -5; print !true; print nil == nil; print 2 >= 1;", `
+0000 OP_CONSTANT         0 '-5'
+0002 OP_PRINT
+0003 OP_FALSE
+0004 OP_PRINT
+0005 OP_TRUE
+0006 OP_PRINT
+0007 OP_TRUE
+0008 OP_PRINT`},
+		{`print "con" + "cat" + "enate";`, `
+0000 OP_CONSTANT         0 'concatenate'
+0002 OP_PRINT`},
+		// only the operands that are literals fold
+		{"var x; print x * (2 + 3);", `
+0000 OP_NIL
+0001 OP_DEFINE_GLOBAL    0 'x'
+0003 OP_GET_GLOBAL       0 'x'
+0005 OP_CONSTANT         0 '5'
+0007 OP_MULTIPLY
+0008 OP_PRINT`},
+		// the runtime error stays at runtime
+		{`print -"a";`, `
+0000 OP_CONSTANT         0 'a'
+0002 OP_NEGATE
+0003 OP_PRINT`},
+		{"print (true and 3) + (false or 4); print false and f();", `
+0000 OP_CONSTANT         0 '7'
+0002 OP_PRINT
+0003 OP_FALSE
+0004 OP_PRINT`},
+		{"if (false) print 1; else print 2; if (1) print 3;", `
+0000 OP_CONSTANT         0 '2'
+0002 OP_PRINT
+0003 OP_CONSTANT         1 '3'
+0005 OP_PRINT`},
+		{"while (false) print 1; while (true) print 2;", `
+0000 OP_CONSTANT         0 '2'
+0002 OP_PRINT
+0003 OP_LOOP             3 -> 0`},
+	}
+	// every script ends in the same implicit return
+	implicitReturn := regexp.MustCompile(`\n\d{4} OP_NIL\n\d{4} OP_RETURN$`)
+	for _, test := range tests {
+		function, err := Compile(test.source, Config{})
+		if err != nil {
+			t.Fatalf("%s: %v", test.source, err)
+		}
+		var out strings.Builder
+		DisassembleChunk(&out, &function.chunk, "script")
+		got := strings.TrimSuffix(strings.TrimPrefix(out.String(), "== script =="), "\n")
+		got = implicitReturn.ReplaceAllString(got, "")
+		if got != test.want {
+			t.Errorf("%s: got%s\nwant%s", test.source, got, test.want)
+		}
+	}
+}
+
+func TestDeadBranchesAreStillChecked(t *testing.T) {
+	_, err := Compile("if (false) { print 1 +; }", Config{})
+	var compileErr *CompileError
+	if !errors.As(err, &compileErr) {
+		t.Errorf("got %v, want the error in the dead branch reported", err)
+	}
+}
+
+func TestCompileREPLEchoesTrailingExpression(t *testing.T) {
+	tests := []struct {
+		source string
+		output string
+		script string
+	}{
+		{"1 + 2", "3\n", "print 1 + 2;"},
+		{"var a = 1; a", "1\n", "var a = 1; print a;"},
+		{"print 4;", "4\n", "print 4;"},
+		{"fun f() { return 5; }\nf() // five", "5\n", "fun f() { return 5; }\nprint f(); // five"},
+	}
+	for _, test := range tests {
+		var out strings.Builder
+		config := Config{Stdout: &out}
+		function, script, err := CompileREPL(test.source, config)
+		if err != nil {
+			t.Errorf("%q: %v", test.source, err)
+			continue
+		}
+		if err := NewVM(config).Interpret(function); err != nil {
+			t.Errorf("%q: %v", test.source, err)
+		}
+		if out.String() != test.output || script != test.script {
+			t.Errorf("%q: printed %q and script %q, want %q and %q", test.source, out.String(), script, test.output, test.script)
+		}
+	}
+
+	// only a top-level expression at the very end is echoed
+	for _, source := range []string{"1 + 2 print 3;", "{ 1 }", "fun f() { 1 }"} {
+		var compileErr *CompileError
+		if _, _, err := CompileREPL(source, Config{}); !errors.As(err, &compileErr) {
+			t.Errorf("%q: got %v, want a compile error", source, err)
+		}
+	}
+	var compileErr *CompileError
+	if _, err := Compile("1 + 2", Config{}); !errors.As(err, &compileErr) {
+		t.Errorf("Compile echoed an expression: %v", err)
+	}
+}
@@ -0,0 +1,25 @@
+package lox
+
+import (
+	"io"
+	"os"
+)
+
+// Config holds the settings owned by one compiler or VM instance, so that
+// several scripts can be compiled and run concurrently in one process.
+type Config struct {
+	Disassemble bool      // dump the bytecode of every function after compiling it
+	Trace       bool      // print the stack and each instruction as the VM executes it
+	Stdout      io.Writer // destination of print statements and debug output
+	Args        []string  // command-line arguments visible to the script through argc() and arg()
+	File        string    // name of the source file, shown in error positions
+	MaxSteps    int       // instructions Interpret may execute before failing with a runtime error, 0 for no limit
+	MaxString   int       // longest string in bytes that + may build before failing with a runtime error, 0 for no limit
+}
+
+func (config Config) withDefaults() Config {
+	if config.Stdout == nil {
+		config.Stdout = os.Stdout
+	}
+	return config
+}
@@ -0,0 +1,166 @@
+package lox
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+)
+
+func SimpleInstruction(out io.Writer, name string, offset int) int {
+	fmt.Fprintf(out, "%s\n", name)
+	return offset + 1
+}
+
+func ConstInstruction(out io.Writer, name string, width int, chunk *Chunk, offset int) int {
+	constant_index := chunk.readIndex(offset+1, width)
+	fmt.Fprintf(out, "%-16s %4d '", name, constant_index)
+	fmt.Fprintf(out, "%v'\n", chunk.constants[constant_index])
+	return offset + 1 + width
+}
+
+func ByteInstruction(out io.Writer, name string, chunk *Chunk, offset int) int {
+	fmt.Fprintf(out, "%-16s %4d\n", name, chunk.bcodes[offset+1])
+	return offset + 2
+}
+
+func IndexInstruction(out io.Writer, name string, width int, chunk *Chunk, offset int) int {
+	fmt.Fprintf(out, "%-16s %4d\n", name, chunk.readIndex(offset+1, width))
+	return offset + 1 + width
+}
+
+func GlobalInstruction(out io.Writer, name string, width int, chunk *Chunk, offset int) int {
+	slot := chunk.readIndex(offset+1, width)
+	fmt.Fprintf(out, "%-16s %4d '%s'\n", name, slot, chunk.globals[slot])
+	return offset + 1 + width
+}
+
+func JumpInstruction(out io.Writer, name string, width int, sign int, chunk *Chunk, offset int) int {
+	jump := chunk.readIndex(offset+1, width)
+	next := offset + 1 + width
+	fmt.Fprintf(out, "%-16s %4d -> %d\n", name, offset, next+sign*jump)
+	return next
+}
+
+func PropertyInstruction(out io.Writer, name string, width int, cacheWidth int, chunk *Chunk, offset int) int {
+	constant_index := chunk.readIndex(offset+1, width)
+	cache := chunk.readIndex(offset+1+width, cacheWidth)
+	fmt.Fprintf(out, "%-16s %4d '", name, constant_index)
+	fmt.Fprintf(out, "%v' (cache %d)\n", chunk.constants[constant_index], cache)
+	return offset + 1 + width + cacheWidth
+}
+
+func InvokeInstruction(out io.Writer, name string, width int, cacheWidth int, chunk *Chunk, offset int) int {
+	constant_index := chunk.readIndex(offset+1, width)
+	cache := chunk.readIndex(offset+1+width, cacheWidth)
+	argCount := chunk.bcodes[offset+1+width+cacheWidth]
+	fmt.Fprintf(out, "%-16s (%d args) %4d '", name, argCount, constant_index)
+	fmt.Fprintf(out, "%v' (cache %d)\n", chunk.constants[constant_index], cache)
+	return offset + 2 + width + cacheWidth
+}
+
+func ClosureInstruction(out io.Writer, name string, width int, chunk *Chunk, offset int) int {
+	offset++
+	constant := chunk.readIndex(offset, width)
+	offset += width
+	fmt.Fprintf(out, "%-16s %4d ", name, constant)
+	fmt.Fprintf(out, "%s", chunk.constants[constant].String())
+	fmt.Fprintf(out, "\n")
+	function, _ := chunk.constants[constant].GetFunction()
+	for i := 0; i < function.upValueCount; i++ {
+		isLocal, index, next := chunk.upvalueDescriptor(offset)
+		var msg string = "upvalue"
+		if isLocal {
+			msg = "local"
+		}
+		fmt.Fprintf(out, "%04d      |                     %s %d\n", offset, msg, index)
+		offset = next
+	}
+	return offset
+}
+
+func DisassembleInstruction(out io.Writer, chunk *Chunk, offset int) int {
+	fmt.Fprintf(out, "%04d ", offset)
+
+	instruction := chunk.bcodes[offset]
+	info, ok := LookupOp(instruction)
+	if !ok {
+		fmt.Fprintf(out, "Unknown opcode %v\n", instruction)
+		return offset + 1
+	}
+	switch info.Operand {
+	case OPERAND_CONSTANT, OPERAND_CONSTANT_LONG:
+		return ConstInstruction(out, info.Name, constantWidth(info.Operand), chunk, offset)
+	case OPERAND_LOCAL, OPERAND_UPVALUE, OPERAND_ARGC:
+		return ByteInstruction(out, info.Name, chunk, offset)
+	case OPERAND_LOCAL_LONG, OPERAND_UPVALUE_LONG:
+		return IndexInstruction(out, info.Name, operandWidth(info.Operand), chunk, offset)
+	case OPERAND_GLOBAL, OPERAND_GLOBAL_LONG:
+		return GlobalInstruction(out, info.Name, operandWidth(info.Operand), chunk, offset)
+	case OPERAND_JUMP, OPERAND_JUMP_LONG:
+		return JumpInstruction(out, info.Name, operandWidth(info.Operand), 1, chunk, offset)
+	case OPERAND_LOOP, OPERAND_LOOP_LONG:
+		return JumpInstruction(out, info.Name, operandWidth(info.Operand), -1, chunk, offset)
+	case OPERAND_INVOKE, OPERAND_INVOKE_LONG:
+		return InvokeInstruction(out, info.Name, constantWidth(info.Operand), cacheWidth(info.Operand), chunk, offset)
+	case OPERAND_PROPERTY, OPERAND_PROPERTY_LONG:
+		return PropertyInstruction(out, info.Name, constantWidth(info.Operand), cacheWidth(info.Operand), chunk, offset)
+	case OPERAND_CLOSURE, OPERAND_CLOSURE_LONG:
+		return ClosureInstruction(out, info.Name, constantWidth(info.Operand), chunk, offset)
+	default:
+		return SimpleInstruction(out, info.Name, offset)
+	}
+}
+
+func DisassembleChunk(out io.Writer, chunk *Chunk, name string) {
+	fmt.Fprintf(out, "== %s ==\n", name)
+	for offset := 0; offset < len(chunk.bcodes); {
+		offset = DisassembleInstruction(out, chunk, offset)
+	}
+}
+
+// DisassembleFunction prints the chunk of function followed by the chunks of
+// every function nested in its constant pool.
+func DisassembleFunction(out io.Writer, function *LoxFunction) {
+	DisassembleChunk(out, &function.chunk, NormalizedFuncName(function.name))
+	for _, constant := range function.chunk.constants {
+		if nested, ok := constant.GetFunction(); ok {
+			DisassembleFunction(out, nested)
+		}
+	}
+}
+
+// DisassembleValue prints the bytecode behind a function, closure, bound
+// method or every method of a class. It reports false for values without code.
+func DisassembleValue(out io.Writer, value Value) bool {
+	if function, ok := value.GetFunction(); ok {
+		DisassembleFunction(out, function)
+	} else if closure, ok := value.GetClosure(); ok {
+		DisassembleFunction(out, closure.function)
+	} else if boundMethod, ok := value.GetBoundMethod(); ok {
+		DisassembleFunction(out, boundMethod.method.function)
+	} else if klass, ok := value.GetClass(); ok {
+		for _, name := range slices.Sorted(maps.Keys(klass.methods)) {
+			DisassembleValue(out, klass.methods[name])
+		}
+	} else {
+		return false
+	}
+	return true
+}
+
+func DebugVM(vm *VM) {
+	out := vm.config.Stdout
+	frame := &vm.frames[vm.frameCount-1]
+	fmt.Fprint(out, "          ")
+	for i := 0; i < vm.vstackCount; i++ {
+		if i == frame.slots_base {
+			fmt.Fprint(out, "^")
+		}
+		fmt.Fprint(out, "[ ")
+		fmt.Fprintf(out, "%s", vm.vstack[i].String())
+		fmt.Fprint(out, " ]")
+	}
+	fmt.Fprint(out, "\n")
+	DisassembleInstruction(out, &frame.closure.function.chunk, frame.ip)
+}
@@ -0,0 +1,41 @@
+package lox
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDisassembleValue(t *testing.T) {
+	config := Config{Stdout: &strings.Builder{}}
+	function, err := Compile("class A { b() { return 1; } a() { return 2; } }\nvar bound = A().a;\nvar n = 1;\n", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := NewVM(config)
+	if err := vm.Interpret(function); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		global string
+		want   []string // the chunks printed, in order
+	}{
+		{"A", []string{"<fn a>", "<fn b>"}},
+		{"bound", []string{"<fn a>"}},
+		{"clock", nil},
+		{"n", nil},
+	}
+	for _, test := range tests {
+		value, _ := vm.GetGlobal(test.global)
+		var out strings.Builder
+		ok := DisassembleValue(&out, value)
+		var got []string
+		for _, line := range strings.Split(out.String(), "\n") {
+			if name, found := strings.CutPrefix(line, "== "); found {
+				got = append(got, strings.TrimSuffix(name, " =="))
+			}
+		}
+		if ok != (test.want != nil) || strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("%s: printed %q (ok %v), want %q", test.global, got, ok, test.want)
+		}
+	}
+}
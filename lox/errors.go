@@ -0,0 +1,91 @@
+package lox
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Diagnostic is a single problem reported by the compiler, at the start of
+// the offending token.
+type Diagnostic struct {
+	Position
+	Token   string // lexeme of the offending token, empty at end of input or for scanner errors
+	AtEnd   bool
+	Message string
+	Notes   []Note // related places, such as an earlier declaration
+}
+
+// Note points at source related to a Diagnostic.
+type Note struct {
+	Position
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	var where string
+	if d.AtEnd {
+		where = " at end"
+	} else if d.Token != "" {
+		where = fmt.Sprintf(" at '%s'", d.Token)
+	}
+	return fmt.Sprintf("%s: Error%s: %s", d.Position, where, d.Message)
+}
+
+// CompileError is returned by Compile when the source has syntax or
+// resolution errors. It carries every diagnostic reported before giving up.
+type CompileError struct {
+	Diagnostics []Diagnostic
+}
+
+func (e *CompileError) Error() string {
+	lines := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		lines[i] = d.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// StackFrame is one active call at the point a runtime error happened,
+// positioned at the instruction the frame was executing.
+type StackFrame struct {
+	Function string // empty for the top-level script
+	Position
+}
+
+func (f StackFrame) String() string {
+	if f.Function == "" {
+		return fmt.Sprintf("%s in script", f.Position)
+	}
+	return fmt.Sprintf("%s in %s()", f.Position, f.Function)
+}
+
+// RuntimeError is returned by VM.Interpret when the script fails while
+// running. Trace lists the call frames innermost first.
+type RuntimeError struct {
+	Message string
+	Hint    string // such as "did you mean 'count'?", empty if there is none
+	Trace   []StackFrame
+}
+
+// ExitError is returned by VM.Interpret when the script called exit().
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+func (e *RuntimeError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(e.Message)
+	if e.Hint != "" {
+		sb.WriteString("\nhelp: ")
+		sb.WriteString(e.Hint)
+	}
+	for _, frame := range e.Trace {
+		sb.WriteString("\n")
+		sb.WriteString(frame.String())
+	}
+	return sb.String()
+}
@@ -0,0 +1,143 @@
+package lox
+
+// codeMark is a point in the current chunk that the compiler can roll the
+// code and constant pool back to, to replace an expression it has already
+// emitted by its value or to drop a branch that can never run.
+type codeMark struct {
+	code      int
+	constants int
+}
+
+func (parser *Parser) mark() codeMark {
+	chunk := parser.currentChunk()
+	return codeMark{len(chunk.bcodes), len(chunk.constants)}
+}
+
+// discard drops everything emitted since mark. The code after mark must not
+// be the target of any jump from before it.
+func (parser *Parser) discard(mark codeMark) {
+	chunk := parser.currentChunk()
+	chunk.truncate(mark.code)
+	for _, constant := range chunk.constants[mark.constants:] {
+		if key, ok := constantKeyOf(constant); ok {
+			delete(parser.compiler.constants, key)
+		}
+	}
+	chunk.constants = chunk.constants[:mark.constants]
+}
+
+// literal returns the value loaded by the code from start to end if that
+// code is a single literal: a number, a string, nil, true or false.
+func (chunk *Chunk) literal(start int, end int) (Value, bool) {
+	code := chunk.bcodes[start:end]
+	switch {
+	case len(code) == 1 && code[0] == OP_NIL:
+		return NilVal(), true
+	case len(code) == 1 && (code[0] == OP_TRUE || code[0] == OP_FALSE):
+		return BoolVal(code[0] == OP_TRUE), true
+	case len(code) == 2 && code[0] == OP_CONSTANT:
+		return chunk.constants[code[1]], true
+	case len(code) == 4 && code[0] == OP_CONSTANT_LONG:
+		return chunk.constants[chunk.readIndex(start+1, 3)], true
+	}
+	return NilVal(), false
+}
+
+// literalSince is the value of the code emitted since mark, if it is a
+// literal.
+func (parser *Parser) literalSince(mark codeMark) (Value, bool) {
+	return parser.currentChunk().literal(mark.code, parser.currentChunkSize())
+}
+
+// emitLiteral emits the instruction that loads value.
+func (parser *Parser) emitLiteral(value Value) {
+	if value.IsNil() {
+		parser.emitByte(OP_NIL)
+	} else if b, ok := value.GetBool(); ok && b {
+		parser.emitByte(OP_TRUE)
+	} else if ok {
+		parser.emitByte(OP_FALSE)
+	} else {
+		parser.emitConstant(value)
+	}
+}
+
+// foldUnary replaces the operand emitted since start by the result of ops
+// if the operand is a literal and the VM wouldn't fail on it.
+func (parser *Parser) foldUnary(start codeMark, ops ...byte) bool {
+	operand, ok := parser.literalSince(start)
+	if !ok {
+		return false
+	}
+	return parser.fold(start, ops, operand)
+}
+
+// foldBinary replaces the operands emitted since left, the right one
+// starting at right, by the result of ops if both are literals and the VM
+// wouldn't fail on them.
+func (parser *Parser) foldBinary(left codeMark, right codeMark, ops ...byte) bool {
+	a, ok := parser.currentChunk().literal(left.code, right.code)
+	if !ok {
+		return false
+	}
+	b, ok := parser.literalSince(right)
+	if !ok {
+		return false
+	}
+	return parser.fold(left, ops, a, b)
+}
+
+func (parser *Parser) fold(start codeMark, ops []byte, operands ...Value) bool {
+	result, ok := foldOp(ops[0], operands...)
+	for _, op := range ops[1:] {
+		if !ok {
+			return false
+		}
+		result, ok = foldOp(op, result)
+	}
+	if !ok {
+		return false
+	}
+	parser.discard(start)
+	parser.emitLiteral(result)
+	return true
+}
+
+// foldOp computes the value instruction op leaves on the stack for the given
+// operands, or reports false if it would be a runtime error, which has to
+// happen when the program runs instead.
+func foldOp(op byte, operands ...Value) (Value, bool) {
+	switch op {
+	case OP_NOT:
+		return BoolVal(isfalsey(operands[0])), true
+	case OP_NEGATE:
+		n, ok := operands[0].GetFloat()
+		return FloatVal(-n), ok
+	case OP_EQUAL:
+		return BoolVal(IsValueEqual(&operands[0], &operands[1])), true
+	}
+	if a, ok := operands[0].GetString(); ok && op == OP_ADD {
+		b, ok := operands[1].GetString()
+		return StringVal(a + b), ok
+	}
+	a, okA := operands[0].GetFloat()
+	b, okB := operands[1].GetFloat()
+	if !okA || !okB {
+		return NilVal(), false
+	}
+	switch op {
+	case OP_ADD:
+		return FloatVal(a + b), true
+	case OP_SUBTRACT:
+		return FloatVal(a - b), true
+	case OP_MULTIPLY:
+		return FloatVal(a * b), true
+	case OP_DIVIDE:
+		return FloatVal(a / b), true
+	case OP_GREATER:
+		return BoolVal(a > b), true
+	case OP_LESS:
+		return BoolVal(a < b), true
+	}
+	return NilVal(), false
+}
@@ -0,0 +1,66 @@
+package lox
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+// fuzzMaxSteps keeps FuzzRun from spending its time in infinite loops, and
+// fuzzMaxString from spending its memory on strings that double in a loop.
+const (
+	fuzzMaxSteps  = 100000
+	fuzzMaxString = 1 << 20
+)
+
+func addTestcaseSeeds(f *testing.F) {
+	for _, source := range loadTestcases(f) {
+		f.Add(source)
+	}
+}
+
+func FuzzScanToken(f *testing.F) {
+	addTestcaseSeeds(f)
+	f.Fuzz(func(t *testing.T, source string) {
+		scanner := NewScanner(source)
+		// every token but EOF consumes at least one byte
+		for range len(source) + 1 {
+			token := scanner.ScanToken()
+			if token.token_type == TOKEN_EOF {
+				return
+			}
+			if token.pos.Offset < 0 || token.pos.Offset > len(source) {
+				t.Fatalf("token %q at offset %d outside of source", token.lexeme, token.pos.Offset)
+			}
+		}
+		t.Fatal("scanner did not reach the end of the source")
+	})
+}
+
+func FuzzCompile(f *testing.F) {
+	addTestcaseSeeds(f)
+	f.Fuzz(func(t *testing.T, source string) {
+		_, err := Compile(source, Config{Stdout: io.Discard})
+		var compileErr *CompileError
+		if err != nil && !errors.As(err, &compileErr) {
+			t.Fatalf("compile failed without diagnostics: %v", err)
+		}
+	})
+}
+
+func FuzzRun(f *testing.F) {
+	addTestcaseSeeds(f)
+	f.Fuzz(func(t *testing.T, source string) {
+		config := Config{Stdout: io.Discard, MaxSteps: fuzzMaxSteps, MaxString: fuzzMaxString}
+		function, err := Compile(source, config)
+		if err != nil {
+			return
+		}
+		err = NewVM(config).Interpret(function)
+		var runtimeErr *RuntimeError
+		var exitErr *ExitError
+		if err != nil && !errors.As(err, &runtimeErr) && !errors.As(err, &exitErr) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
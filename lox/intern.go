@@ -0,0 +1,11 @@
+package lox
+
+import "unique"
+
+// Intern returns the canonical copy of s shared by every compiler in the
+// process. Identifier lexemes are slices of their source text; interning
+// them lets each name be stored once and lets the source be collected once
+// it has been compiled. It is safe for concurrent use.
+func Intern(s string) string {
+	return unique.Make(s).Value()
+}
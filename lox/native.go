@@ -0,0 +1,70 @@
+package lox
+
+import (
+	"math"
+	"os"
+	"time"
+)
+
+func ClockNative(vm *VM, argCount int, args []Value) (Value, bool) {
+	elapsed := time.Since(vm.startTime)
+	seconds := elapsed.Seconds()
+	return FloatVal(seconds), true
+}
+
+// ArgcNative returns the number of command-line arguments passed to the script.
+func ArgcNative(vm *VM, argCount int, args []Value) (Value, bool) {
+	if argCount != 0 {
+		vm.runtimeError("Expected 0 arguments but got %d.", argCount)
+		return NilVal(), false
+	}
+	return FloatVal(float64(len(vm.config.Args))), true
+}
+
+// ArgNative returns the command-line argument at index n, counting from 0.
+func ArgNative(vm *VM, argCount int, args []Value) (Value, bool) {
+	if argCount != 1 {
+		vm.runtimeError("Expected 1 arguments but got %d.", argCount)
+		return NilVal(), false
+	}
+	n, ok := args[0].GetFloat()
+	if !ok || n != math.Trunc(n) || n < 0 || n >= float64(len(vm.config.Args)) {
+		vm.runtimeError("Argument index must be an integer between 0 and argc() - 1.")
+		return NilVal(), false
+	}
+	return StringVal(vm.config.Args[int(n)]), true
+}
+
+// EnvNative returns the value of an environment variable, or nil if it is unset.
+func EnvNative(vm *VM, argCount int, args []Value) (Value, bool) {
+	if argCount != 1 {
+		vm.runtimeError("Expected 1 arguments but got %d.", argCount)
+		return NilVal(), false
+	}
+	name, ok := args[0].GetString()
+	if !ok {
+		vm.runtimeError("Environment variable name must be a string.")
+		return NilVal(), false
+	}
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return NilVal(), true
+	}
+	return StringVal(value), true
+}
+
+// ExitNative stops the script with the given exit status, which must fit in
+// the 0 to 255 a process can report.
+func ExitNative(vm *VM, argCount int, args []Value) (Value, bool) {
+	if argCount != 1 {
+		vm.runtimeError("Expected 1 arguments but got %d.", argCount)
+		return NilVal(), false
+	}
+	code, ok := args[0].GetFloat()
+	if !ok || code != math.Trunc(code) || code < 0 || code > 255 {
+		vm.runtimeError("Exit code must be an integer between 0 and 255.")
+		return NilVal(), false
+	}
+	vm.Exit(int(code))
+	return NilVal(), false
+}
@@ -0,0 +1,5 @@
+//go:build !race
+
+package lox
+
+const raceEnabled = false
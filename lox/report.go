@@ -0,0 +1,228 @@
+package lox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ANSI escapes used by WriteError when color is on.
+const (
+	colorReset = "\x1b[0m"
+	colorError = "\x1b[1;31m"
+	colorNote  = "\x1b[1;32m"
+	colorGrid  = "\x1b[1;34m"
+	colorBold  = "\x1b[1m"
+)
+
+// errorWriter renders diagnostics against the source they refer to.
+type errorWriter struct {
+	out    io.Writer
+	source string
+	color  bool
+}
+
+func (w *errorWriter) paint(color string, s string) string {
+	if !w.color {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// WriteError prints err in the style of rustc: the message, then the source
+// line it refers to with the offending token underlined, then any notes.
+// Runtime errors are followed by their stack trace. Errors that are not from
+// the compiler or the VM are printed as they are. Snippets are only shown
+// for positions inside source.
+func WriteError(out io.Writer, err error, source string, color bool) {
+	w := &errorWriter{out: out, source: source, color: color}
+	var compileErr *CompileError
+	var runtimeErr *RuntimeError
+	switch {
+	case errors.As(err, &compileErr):
+		for i, d := range compileErr.Diagnostics {
+			if i > 0 {
+				fmt.Fprintln(out)
+			}
+			w.header("error", colorError, d.Message)
+			w.snippet(d.Position, colorError)
+			for _, note := range d.Notes {
+				w.header("note", colorNote, note.Message)
+				w.snippet(note.Position, colorNote)
+			}
+		}
+	case errors.As(err, &runtimeErr):
+		w.header("error", colorError, runtimeErr.Message)
+		if len(runtimeErr.Trace) > 0 {
+			w.snippet(runtimeErr.Trace[0].Position, colorError)
+		}
+		if runtimeErr.Hint != "" {
+			fmt.Fprintf(out, "%s %s\n", w.paint(colorNote, "help:"), runtimeErr.Hint)
+		}
+		fmt.Fprintln(out, w.paint(colorBold, "stack trace:"))
+		for _, frame := range runtimeErr.Trace {
+			fmt.Fprintf(out, "  %s\n", frame)
+		}
+	default:
+		fmt.Fprintln(out, err)
+	}
+}
+
+func (w *errorWriter) header(kind string, color string, message string) {
+	fmt.Fprintf(w.out, "%s%s\n", w.paint(color, kind+":"), w.paint(colorBold, " "+message))
+}
+
+// snippet prints the location of pos and the line it is on, underlining the
+// token that starts there.
+func (w *errorWriter) snippet(pos Position, color string) {
+	lineText, ok := sourceLine(w.source, pos)
+	if !ok {
+		fmt.Fprintf(w.out, "%s %s\n", w.paint(colorGrid, "-->"), pos)
+		return
+	}
+	number := strconv.Itoa(pos.Line)
+	gutter := strings.Repeat(" ", len(number))
+	fmt.Fprintf(w.out, "%s%s %s\n", gutter, w.paint(colorGrid, "-->"), pos)
+	fmt.Fprintf(w.out, "%s %s\n", gutter, w.paint(colorGrid, "|"))
+	fmt.Fprintf(w.out, "%s %s %s\n", w.paint(colorGrid, number), w.paint(colorGrid, "|"), lineText)
+
+	// keep tabs so the underline lines up with the source above it; the column
+	// can be past the end of the text when it points at a stripped \r
+	column := min(pos.Column-1, len(lineText))
+	var indent strings.Builder
+	for _, c := range lineText[:column] {
+		if c == '\t' {
+			indent.WriteByte('\t')
+		} else {
+			indent.WriteByte(' ')
+		}
+	}
+	length := max(min(tokenLength(w.source, pos.Offset), len(lineText)-column), 1)
+	fmt.Fprintf(w.out, "%s %s %s%s\n", gutter, w.paint(colorGrid, "|"), indent.String(), w.paint(color, strings.Repeat("^", length)))
+}
+
+// sourceLine returns the text of the line pos is on, or false if pos doesn't
+// point into source, as for bytecode loaded without its source.
+func sourceLine(source string, pos Position) (string, bool) {
+	if pos.Line < 1 || pos.Column < 1 || pos.Offset > len(source) || pos.Column-1 > pos.Offset {
+		return "", false
+	}
+	start := pos.Offset - (pos.Column - 1)
+	if strings.Contains(source[start:pos.Offset], "\n") {
+		return "", false
+	}
+	end := strings.IndexByte(source[start:], '\n')
+	if end < 0 {
+		end = len(source) - start
+	}
+	return strings.TrimSuffix(source[start:start+end], "\r"), true
+}
+
+// tokenLength is the length in bytes of the token that starts at offset.
+func tokenLength(source string, offset int) int {
+	if offset < 0 || offset >= len(source) {
+		return 0
+	}
+	scanner := Scanner{line: 1, start: offset, current: offset, source: source, keywords: newKeywordTable()}
+	scanner.ScanToken()
+	return scanner.current - offset
+}
+
+// WriteErrorClox prints err the way clox does: a "[line N] Error at 'x':
+// message" line per compile diagnostic, or a runtime error's message followed
+// by a "[line N] in f()" line per frame. Errors that are not from the
+// compiler or the VM are printed as they are.
+func WriteErrorClox(out io.Writer, err error) {
+	var compileErr *CompileError
+	var runtimeErr *RuntimeError
+	switch {
+	case errors.As(err, &compileErr):
+		for _, d := range compileErr.Diagnostics {
+			fmt.Fprintln(out, cloxDiagnostic(d))
+		}
+	case errors.As(err, &runtimeErr):
+		fmt.Fprintln(out, runtimeErr.Message)
+		for _, frame := range runtimeErr.Trace {
+			if frame.Function == "" {
+				fmt.Fprintf(out, "[line %d] in script\n", frame.Line)
+			} else {
+				fmt.Fprintf(out, "[line %d] in %s()\n", frame.Line, frame.Function)
+			}
+		}
+	default:
+		fmt.Fprintln(out, err)
+	}
+}
+
+func cloxDiagnostic(d Diagnostic) string {
+	var where string
+	if d.AtEnd {
+		where = " at end"
+	} else if d.Token != "" {
+		where = fmt.Sprintf(" at '%s'", d.Token)
+	}
+	return fmt.Sprintf("[line %d] Error%s: %s", d.Line, where, d.Message)
+}
+
+type jsonPosition struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+}
+
+type jsonNote struct {
+	jsonPosition
+	Message string `json:"message"`
+}
+
+type jsonDiagnostic struct {
+	jsonPosition
+	Message string     `json:"message"`
+	Notes   []jsonNote `json:"notes,omitempty"`
+}
+
+type jsonFrame struct {
+	jsonPosition
+	Function string `json:"function"`
+}
+
+type jsonError struct {
+	Kind        string           `json:"kind"` // "compile", "runtime" or "error"
+	Message     string           `json:"message,omitempty"`
+	Hint        string           `json:"hint,omitempty"`
+	Diagnostics []jsonDiagnostic `json:"diagnostics,omitempty"`
+	Trace       []jsonFrame      `json:"trace,omitempty"`
+}
+
+// WriteErrorJSON prints err as a single line of JSON for editors and other
+// tools. Positions carry the length of the token they point at, measured in
+// source.
+func WriteErrorJSON(out io.Writer, err error, source string) error {
+	position := func(pos Position) jsonPosition {
+		return jsonPosition{pos.File, pos.Line, pos.Column, pos.Offset, tokenLength(source, pos.Offset)}
+	}
+	report := jsonError{Kind: "error", Message: err.Error()}
+	var compileErr *CompileError
+	var runtimeErr *RuntimeError
+	if errors.As(err, &compileErr) {
+		report = jsonError{Kind: "compile"}
+		for _, d := range compileErr.Diagnostics {
+			diagnostic := jsonDiagnostic{jsonPosition: position(d.Position), Message: d.Message}
+			for _, note := range d.Notes {
+				diagnostic.Notes = append(diagnostic.Notes, jsonNote{position(note.Position), note.Message})
+			}
+			report.Diagnostics = append(report.Diagnostics, diagnostic)
+		}
+	} else if errors.As(err, &runtimeErr) {
+		report = jsonError{Kind: "runtime", Message: runtimeErr.Message, Hint: runtimeErr.Hint}
+		for _, frame := range runtimeErr.Trace {
+			report.Trace = append(report.Trace, jsonFrame{position(frame.Position), frame.Function})
+		}
+	}
+	return json.NewEncoder(out).Encode(report)
+}
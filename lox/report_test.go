@@ -0,0 +1,97 @@
+package lox
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestWriteErrorShowsSnippetAndNotes(t *testing.T) {
+	source := "{\n  var a = 1;\n  var a = 2;\n}\n"
+	_, err := Compile(source, Config{File: "t.lox"})
+	var out bytes.Buffer
+	WriteError(&out, err, source, false)
+	want := `error: Already a variable with this name in this scope.
+ --> t.lox:3:7
+  |
+3 |   var a = 2;
+  |       ^
+note: variable declared here
+ --> t.lox:2:7
+  |
+2 |   var a = 1;
+  |       ^
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestWriteErrorCRLFAtEOF(t *testing.T) {
+	source := "print 1\r"
+	_, err := Compile(source, Config{File: "t.lox"})
+	var out bytes.Buffer
+	WriteError(&out, err, source, false)
+	want := `error: Expect ';' after value.
+ --> t.lox:1:9
+  |
+1 | print 1
+  |        ^
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestWriteErrorJSON(t *testing.T) {
+	source := "var x = 1;\nprint x + nil;\n"
+	function, err := Compile(source, Config{File: "t.lox"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewVM(Config{}).Interpret(function)
+	var out bytes.Buffer
+	if err := WriteErrorJSON(&out, err, source); err != nil {
+		t.Fatal(err)
+	}
+	var report jsonError
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Kind != "runtime" || len(report.Trace) != 1 {
+		t.Fatalf("got %+v", report)
+	}
+	if frame := report.Trace[0]; frame.Line != 2 || frame.Column != 9 || frame.Length != 1 {
+		t.Errorf("frame = %+v, want the '+' at 2:9", frame)
+	}
+}
+
+func TestWriteErrorClox(t *testing.T) {
+	source := "fun f() {\n  return nil + 1;\n}\nf();\n"
+	function, err := Compile(source, Config{File: "t.lox"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewVM(Config{}).Interpret(function)
+	var out bytes.Buffer
+	WriteErrorClox(&out, err)
+	want := "Operands must be two numbers or two strings.\n[line 2] in f()\n[line 4] in script\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestWriteErrorCloxHasNoHint(t *testing.T) {
+	function, err := Compile("var count = 1;\nprint cuont;\n", Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewVM(Config{}).Interpret(function)
+	var out bytes.Buffer
+	WriteErrorClox(&out, err)
+	// clox has no hints, and the compat format must match it byte for byte
+	want := "Undefined variable 'cuont'.\n[line 2] in script\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
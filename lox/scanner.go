@@ -1,6 +1,10 @@
-package main
+package lox
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"strings"
+)
 
 const (
 	TOKEN_LEFT_PAREN byte = iota + 1 // 1
@@ -41,28 +45,67 @@ const (
 	TOKEN_TRUE
 	TOKEN_VAR
 	TOKEN_WHILE
-	TOKEN_BREAK
 	TOKEN_EOF
 	TOKEN_ERROR
 )
 
+const errUnterminatedString = "Unterminated string."
+
+// Position is a location in a source file. Line and Column count from 1,
+// Offset is the byte offset from the start of the source.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+	Offset int
+}
+
+// String formats the position as file:line:col, leaving out the file when
+// the source has no name.
+func (pos Position) String() string {
+	if pos.File == "" {
+		return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", pos.File, pos.Line, pos.Column)
+}
+
 type Token struct {
 	token_type byte
 	lexeme     string
-	line       int
+	pos        Position // where the lexeme starts
 }
 
 type Scanner struct {
-	line    int
-	start   int
-	current int
-	source  string
+	file        string
+	line        int
+	lineStart   int // offset of the first byte of the current line
+	start       int
+	startLine   int
+	startColumn int
+	current     int
+	source      string
+	keywords    map[string]byte
 }
 
-var keyword map[string]byte
+func NewScanner(source string) Scanner {
+	scanner := Scanner{line: 1, start: 0, current: 0, source: source, keywords: newKeywordTable()}
+	scanner.skipShebang()
+	return scanner
+}
 
-func ScannerInit() {
-	keyword = make(map[string]byte)
+// skipShebang skips a leading "#!/usr/bin/env glox" line so scripts can be
+// made executable. The newline is left for skipWhitespace to count.
+func (scanner *Scanner) skipShebang() {
+	if !strings.HasPrefix(scanner.source, "#!") {
+		return
+	}
+	for scanner.peek() != '\n' && !scanner.isAtEnd() {
+		scanner.advance()
+	}
+}
+
+func newKeywordTable() map[string]byte {
+	keyword := make(map[string]byte)
 	keyword["and"] = TOKEN_AND
 	keyword["or"] = TOKEN_OR
 	keyword["true"] = TOKEN_TRUE
@@ -78,8 +121,8 @@ func ScannerInit() {
 	keyword["print"] = TOKEN_PRINT
 	keyword["fun"] = TOKEN_FUN
 	keyword["class"] = TOKEN_CLASS
-	keyword["break"] = TOKEN_BREAK
 	keyword["nil"] = TOKEN_NIL
+	return keyword
 }
 
 func isDigit(c byte) bool {
@@ -134,6 +177,7 @@ func (scanner *Scanner) skipWhitespace() {
 		case '\n':
 			scanner.line++
 			scanner.advance()
+			scanner.lineStart = scanner.current
 		case '/':
 			if scanner.peekNext() != '/' {
 				return
@@ -149,14 +193,14 @@ func (scanner *Scanner) skipWhitespace() {
 
 func (scanner *Scanner) stringLiteral() Token {
 	for scanner.peek() != '"' && !scanner.isAtEnd() {
-		if scanner.peek() == '\n' {
+		if scanner.advance() == '\n' {
 			scanner.line++
+			scanner.lineStart = scanner.current
 		}
-		scanner.advance()
 	}
 
 	if scanner.isAtEnd() {
-		return scanner.ErrorToken("Unterminated string.")
+		return scanner.ErrorToken(errUnterminatedString)
 	}
 
 	scanner.advance()
@@ -183,30 +227,36 @@ func (scanner *Scanner) identiferToken() Token {
 
 	identifer := scanner.source[scanner.start:scanner.current]
 
-	if token_type, ok := keyword[identifer]; ok {
+	if token_type, ok := scanner.keywords[identifer]; ok {
 		return scanner.MakeToken(token_type)
 	}
 
 	return scanner.MakeToken(TOKEN_IDENTIFIER)
 }
 
+func (scanner *Scanner) startPosition() Position {
+	return Position{scanner.file, scanner.startLine, scanner.startColumn, scanner.start}
+}
+
 func (scanner *Scanner) EOFToken() Token {
-	return Token{TOKEN_EOF, "EOF", scanner.line}
+	return Token{TOKEN_EOF, "EOF", scanner.startPosition()}
 }
 
 func (scanner *Scanner) ErrorToken(s string) Token {
-	return Token{TOKEN_ERROR, s, scanner.line}
+	return Token{TOKEN_ERROR, s, scanner.startPosition()}
 }
 
 func (scanner *Scanner) MakeToken(token_type byte) Token {
 	var lexeme string = scanner.source[scanner.start:scanner.current]
-	return Token{token_type, lexeme, scanner.line}
+	return Token{token_type, lexeme, scanner.startPosition()}
 }
 
 func (scanner *Scanner) ScanToken() Token {
 	scanner.skipWhitespace()
 
 	scanner.start = scanner.current
+	scanner.startLine = scanner.line
+	scanner.startColumn = scanner.start - scanner.lineStart + 1
 	if scanner.isAtEnd() {
 		return scanner.EOFToken()
 	}
@@ -270,17 +320,40 @@ func (scanner *Scanner) ScanToken() Token {
 	return scanner.ErrorToken("Unexpected character.")
 }
 
-func DumpToken(token Token) {
-	fmt.Printf("%6d: %2d <%s>\n", token.line, token.token_type, token.lexeme)
+func DumpToken(out io.Writer, token Token) {
+	fmt.Fprintf(out, "%6d:%-3d %2d <%s>\n", token.pos.Line, token.pos.Column, token.token_type, token.lexeme)
 }
 
-func DumpTokens(source string) {
-	scanner := Scanner{1, 0, 0, source}
+func DumpTokens(out io.Writer, source string) {
+	scanner := NewScanner(source)
 	for {
 		token := scanner.ScanToken()
 		if token.token_type == TOKEN_EOF {
 			break
 		}
-		DumpToken(token)
+		DumpToken(out, token)
+	}
+}
+
+// IsIncomplete reports whether source ends inside an unterminated string or
+// with unbalanced '(' or '{', meaning an interactive caller should read more
+// lines before compiling it.
+func IsIncomplete(source string) bool {
+	scanner := NewScanner(source)
+	depth := 0
+	for {
+		token := scanner.ScanToken()
+		switch token.token_type {
+		case TOKEN_LEFT_PAREN, TOKEN_LEFT_BRACE:
+			depth++
+		case TOKEN_RIGHT_PAREN, TOKEN_RIGHT_BRACE:
+			depth--
+		case TOKEN_ERROR:
+			if token.lexeme == errUnterminatedString {
+				return true
+			}
+		case TOKEN_EOF:
+			return depth > 0
+		}
 	}
 }
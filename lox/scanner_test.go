@@ -0,0 +1,52 @@
+package lox
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestIsIncomplete(t *testing.T) {
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{"print 1;", false},
+		{"fun f() {", true},
+		{"fun f() {\n  if (true) {\n    print 1;\n  }", true},
+		{"fun f() {\n  print 1;\n}", false},
+		{"print (1 +", true},
+		{"print (1 + 2);", false},
+		{"print \"one\ntwo", true},
+		{"print \"{\";", false},
+		{"// {", false},
+		{"}", false},
+		{"var a = 1 +", false}, // only open brackets and strings ask for more
+	}
+	for _, test := range tests {
+		if got := IsIncomplete(test.source); got != test.want {
+			t.Errorf("IsIncomplete(%q) = %v, want %v", test.source, got, test.want)
+		}
+	}
+}
+
+func TestShebangIsSkipped(t *testing.T) {
+	var out strings.Builder
+	function, err := Compile("#!/usr/bin/env glox\nprint 1;\n", Config{Stdout: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewVM(Config{Stdout: &out}).Interpret(function); err != nil || out.String() != "1\n" {
+		t.Errorf("got %q, %v", out.String(), err)
+	}
+	// errors after it keep their line numbers
+	_, err = Compile("#!/usr/bin/env glox\nprint 1 +;\n", Config{})
+	var compileErr *CompileError
+	if !errors.As(err, &compileErr) || compileErr.Diagnostics[0].Line != 2 {
+		t.Errorf("got %v, want an error on line 2", err)
+	}
+	// only the first line can be one
+	if _, err := Compile("print 1;\n#!/usr/bin/env glox\n", Config{}); err == nil {
+		t.Errorf("a #! line after the first compiled")
+	}
+}
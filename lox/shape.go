@@ -0,0 +1,94 @@
+package lox
+
+import (
+	"iter"
+	"maps"
+	"slices"
+)
+
+// Limits past which an instance stops sharing a shape and keeps its fields
+// in a map of its own, as objects used as dictionaries would otherwise grow
+// their class's transition tree without bound.
+const (
+	MAX_SHAPE_FIELDS int = 32
+	MAX_CLASS_SHAPES int = 256
+)
+
+// shape is the field layout shared by the instances of a class that added
+// the same fields in the same order. Adding a field moves an instance along
+// a transition to the next shape, creating it the first time.
+type shape struct {
+	slots       map[string]int // index of each field in LoxInstance.values
+	names       []string       // name of the field in each slot
+	transitions map[string]*shape
+}
+
+func newShape() *shape {
+	return &shape{slots: make(map[string]int)}
+}
+
+// transition returns the shape of an instance of klass that has the fields
+// of s and then name, or nil if the instance or the class would have too
+// many to keep a shape.
+func (s *shape) transition(klass *LoxClass, name string) *shape {
+	if next, ok := s.transitions[name]; ok {
+		return next
+	}
+	if len(s.names) == MAX_SHAPE_FIELDS || klass.shapes == MAX_CLASS_SHAPES {
+		return nil
+	}
+	next := &shape{slots: maps.Clone(s.slots), names: append(slices.Clip(s.names), name)}
+	next.slots[name] = len(s.names)
+	if s.transitions == nil {
+		s.transitions = make(map[string]*shape)
+	}
+	s.transitions[name] = next
+	klass.shapes++
+	return next
+}
+
+func (instance *LoxInstance) getField(name string) (Value, bool) {
+	if instance.shape == nil {
+		return tableGet(instance.fields, name)
+	}
+	if slot, ok := instance.shape.slots[name]; ok {
+		return instance.values[slot], true
+	}
+	return NilVal(), false
+}
+
+// setField sets the field name, adding it if the instance doesn't have it.
+func (instance *LoxInstance) setField(name string, value Value) {
+	if instance.shape == nil {
+		tableSet(instance.fields, name, value)
+		return
+	}
+	if slot, ok := instance.shape.slots[name]; ok {
+		instance.values[slot] = value
+		return
+	}
+	next := instance.shape.transition(instance.klass, name)
+	if next == nil {
+		instance.fields = make(map[string]Value, len(instance.values)+1)
+		for slot, value := range instance.values {
+			instance.fields[instance.shape.names[slot]] = value
+		}
+		instance.fields[name] = value
+		instance.shape, instance.values = nil, nil
+		return
+	}
+	if instance.values == nil {
+		instance.values = make([]Value, 0, max(instance.klass.fields, 1))
+	}
+	instance.shape = next
+	instance.values = append(instance.values, value)
+	instance.klass.fields = max(instance.klass.fields, len(instance.values))
+}
+
+// fieldNames yields the names of the fields the instance has.
+func (instance *LoxInstance) fieldNames() iter.Seq[string] {
+	if instance.shape == nil {
+		return maps.Keys(instance.fields)
+	}
+	return slices.Values(instance.shape.names)
+}
@@ -0,0 +1,80 @@
+package lox
+
+import (
+	"iter"
+	"maps"
+)
+
+// closestName returns the candidate nearest to name by edit distance, if it
+// is near enough to be a plausible misspelling. Ties go to the candidate that
+// sorts first so the suggestion doesn't depend on map order. Names shorter
+// than three characters get no suggestion, since one edit away from them is
+// nearly every other short name.
+func closestName(name string, candidates ...iter.Seq[string]) (string, bool) {
+	limit := len([]rune(name)) / 3
+	if limit == 0 {
+		return "", false
+	}
+	best, bestDistance := "", limit+1
+	for _, seq := range candidates {
+		for candidate := range seq {
+			if candidate == name {
+				continue
+			}
+			d := editDistance(name, candidate)
+			if d < bestDistance || (d == bestDistance && candidate < best) {
+				best, bestDistance = candidate, d
+			}
+		}
+	}
+	return best, bestDistance <= limit
+}
+
+// editDistance counts the insertions, deletions, substitutions and swaps of
+// adjacent characters needed to turn a into b.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	// rows i-2, i-1 and i of the distance matrix
+	prev2 := make([]int, len(t)+1)
+	prev := make([]int, len(t)+1)
+	row := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		row[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			row[j] = min(prev[j]+1, row[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				row[j] = min(row[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, row = prev, row, prev2
+	}
+	return prev[len(t)]
+}
+
+// suggest adds a "did you mean" hint to the runtime error just reported when
+// one of the names in tables is a likely misspelling of name.
+func (vm *VM) suggest(name string, tables ...map[string]Value) {
+	var candidates []iter.Seq[string]
+	for _, table := range tables {
+		candidates = append(candidates, maps.Keys(table))
+	}
+	vm.suggestFrom(name, candidates...)
+}
+
+// suggestFrom is suggest for names that are not the keys of a table.
+func (vm *VM) suggestFrom(name string, candidates ...iter.Seq[string]) {
+	runtimeErr, ok := vm.err.(*RuntimeError)
+	if !ok {
+		return
+	}
+	if closest, ok := closestName(name, candidates...); ok {
+		runtimeErr.Hint = "did you mean '" + closest + "'?"
+	}
+}
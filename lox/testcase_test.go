@@ -0,0 +1,154 @@
+package lox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Annotations in testcase/ follow the Crafting Interpreters test suite, and
+// testcase/clox/ holds tests in that suite's layout that glox has to pass
+// byte for byte:
+//
+//	print 1;            // expect: 1
+//	print nil.x;        // expect runtime error: Only instances have properties.
+//	var a = ;           // Error at ';': Expect expression.
+//	// [line 3] Error at end: Expect '}' after block.
+//
+// Expected output lines are matched in file order. A compile error without
+// a [line N] prefix is expected on the line that carries it, as is the first
+// stack frame of a runtime error. [java line N] annotations are for the
+// tree-walking interpreter and are ignored.
+var (
+	expectOutputPattern       = regexp.MustCompile(`// expect: ?(.*)`)
+	expectRuntimeErrorPattern = regexp.MustCompile(`// expect runtime error: (.+)`)
+	expectErrorPattern        = regexp.MustCompile(`// (Error.*)`)
+	expectErrorLinePattern    = regexp.MustCompile(`// \[(?:c )?line (\d+)\] (Error.*)`)
+)
+
+// upstreamSkips are the upstream tests in testcase/clox/ that expect errors
+// for limits glox has lifted, and why each is skipped. Everything else in
+// there has to pass unchanged.
+var upstreamSkips = map[string]string{
+	"limit/loop_too_large.lox":     "glox jumps up to 2^24 bytes",
+	"limit/no_reuse_constants.lox": "glox allows 2^24 constants per chunk",
+	"limit/too_many_constants.lox": "glox allows 2^24 constants per chunk",
+	"limit/too_many_locals.lox":    "glox allows 2^24 locals per function",
+	"limit/too_many_upvalues.lox":  "glox allows 2^24 upvalues per function",
+}
+
+type expectations struct {
+	output        []string
+	compileErrors []string // formatted as "[line N] Error at 'x': message"
+	runtimeError  string
+	runtimeLine   int
+}
+
+func parseExpectations(source string) expectations {
+	var want expectations
+	for i, line := range strings.Split(source, "\n") {
+		lineNumber := i + 1
+		if m := expectOutputPattern.FindStringSubmatch(line); m != nil {
+			want.output = append(want.output, m[1])
+		} else if m := expectRuntimeErrorPattern.FindStringSubmatch(line); m != nil {
+			want.runtimeError, want.runtimeLine = m[1], lineNumber
+		} else if m := expectErrorLinePattern.FindStringSubmatch(line); m != nil {
+			want.compileErrors = append(want.compileErrors, fmt.Sprintf("[line %s] %s", m[1], m[2]))
+		} else if m := expectErrorPattern.FindStringSubmatch(line); m != nil {
+			want.compileErrors = append(want.compileErrors, fmt.Sprintf("[line %d] %s", lineNumber, m[1]))
+		}
+	}
+	return want
+}
+
+func outputLines(out string) []string {
+	if out == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+}
+
+// stderrLines is what clox would print to stderr for err.
+func stderrLines(err error) []string {
+	var stderr bytes.Buffer
+	WriteErrorClox(&stderr, err)
+	return outputLines(stderr.String())
+}
+
+// diffLines lists want and got side by side, marking the lines that differ.
+func diffLines(want, got []string) string {
+	var sb strings.Builder
+	for i := range max(len(want), len(got)) {
+		switch {
+		case i >= len(got):
+			fmt.Fprintf(&sb, "  - %s\n", want[i])
+		case i >= len(want):
+			fmt.Fprintf(&sb, "  + %s\n", got[i])
+		case want[i] != got[i]:
+			fmt.Fprintf(&sb, "  - %s\n  + %s\n", want[i], got[i])
+		default:
+			fmt.Fprintf(&sb, "    %s\n", want[i])
+		}
+	}
+	return sb.String()
+}
+
+func checkExpectations(t *testing.T, source string) {
+	want := parseExpectations(source)
+	var out bytes.Buffer
+	config := Config{Stdout: &out}
+
+	function, err := Compile(source, config)
+	var got []string
+	var compileErr *CompileError
+	if errors.As(err, &compileErr) {
+		got = stderrLines(err)
+	} else if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	if !slices.Equal(want.compileErrors, got) {
+		t.Errorf("compile errors (- want, + got):\n%s", diffLines(want.compileErrors, got))
+	}
+	if err != nil {
+		return
+	}
+
+	err = NewVM(config).Interpret(function)
+	if got := outputLines(out.String()); !slices.Equal(want.output, got) {
+		t.Errorf("output (- want, + got):\n%s", diffLines(want.output, got))
+	}
+	var runtimeErr *RuntimeError
+	switch {
+	case errors.As(err, &runtimeErr):
+		// the message, then a stack trace starting at the failing line
+		got := stderrLines(err)
+		frame := fmt.Sprintf("[line %d] in ", want.runtimeLine)
+		if want.runtimeError == "" {
+			t.Errorf("unexpected runtime error: %v", err)
+		} else if got[0] != want.runtimeError {
+			t.Errorf("runtime error %q, want %q", got[0], want.runtimeError)
+		} else if len(got) < 2 || !strings.HasPrefix(got[1], frame) {
+			t.Errorf("runtime error trace %q, want it to start with %q", got[1:], frame)
+		}
+	case err != nil:
+		t.Errorf("unexpected error: %v", err)
+	case want.runtimeError != "":
+		t.Errorf("expected runtime error %q on line %d", want.runtimeError, want.runtimeLine)
+	}
+}
+
+func TestTestcasesMatchExpectations(t *testing.T) {
+	for path, source := range loadTestcases(t) {
+		t.Run(path, func(t *testing.T) {
+			if reason, ok := upstreamSkips[strings.TrimPrefix(path, testcaseDir+"/clox/")]; ok {
+				t.Skip(reason)
+			}
+			t.Parallel()
+			checkExpectations(t, source)
+		})
+	}
+}
@@ -0,0 +1,456 @@
+package lox
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+type ValueType uint8
+
+const (
+	VAL_NIL ValueType = iota
+	VAL_BOOL
+	VAL_NUMBER
+	VAL_STRING
+	VAL_FUNCTION
+	VAL_NATIVE
+	VAL_CLOSURE
+	VAL_CLASS
+	VAL_INSTANCE
+	VAL_BOUND_METHOD
+)
+
+// Value is a Lox value tagged with its type. Numbers and booleans are held
+// in number, so making and checking them never allocates; strings and
+// objects are held in obj.
+type Value struct {
+	vtype  ValueType
+	number float64 // the number, or 1 for true and 0 for false
+	obj    any     // string, *LoxFunction, NativeFn, *LoxClosure, *LoxClass, *LoxInstance or *BoundMethod
+}
+
+type LoxFunction struct {
+	arity        int
+	chunk        Chunk
+	name         string
+	upValueCount int
+	maxStack     int // stack slots the frame needs, known once Verify has run
+}
+
+type LoxClosure struct {
+	function *LoxFunction
+	upvalues []*UpvalueObj
+	globals  []int         // VM global slot of each global slot of the function's program
+	caches   []methodCache // inline caches of the function's program, see Chunk.caches
+}
+
+type UpvalueObj struct {
+	ref      *Value
+	location int
+	closed   Value
+	next     *UpvalueObj
+}
+
+type LoxClass struct {
+	name    string
+	methods map[string]Value
+	version int    // bumped whenever methods changes, invalidating inline caches
+	shape   *shape // layout of a new instance, the root of the class's shape tree
+	shapes  int    // number of shapes in the tree
+	fields  int    // most fields an instance has had in values, the capacity new instances start with
+}
+
+// LoxInstance keeps its fields in values, laid out by a shape it shares with
+// other instances of its class, or in fields once it has too many for one.
+type LoxInstance struct {
+	klass  *LoxClass
+	shape  *shape // nil when the fields are in fields
+	values []Value
+	fields map[string]Value
+}
+
+type BoundMethod struct {
+	receiver Value
+	method   *LoxClosure
+}
+
+// NativeFn implements a function written in Go. It returns false after
+// reporting a runtime error through the VM or stopping it with Exit.
+type NativeFn func(*VM, int, []Value) (Value, bool)
+
+func NewFunction() *LoxFunction {
+	return &LoxFunction{arity: 0, name: "", chunk: Chunk{}, upValueCount: 0}
+}
+
+func NewClosure(function *LoxFunction) *LoxClosure {
+	closure := LoxClosure{function: function, upvalues: make([]*UpvalueObj, 0)}
+	for i := 0; i < function.upValueCount; i++ {
+		closure.upvalues = append(closure.upvalues, nil)
+	}
+	return &closure
+}
+
+func NewUpvalueObj(ref *Value, slot int) *UpvalueObj {
+	upvalue := UpvalueObj{ref: ref, location: slot, next: nil, closed: Value{}}
+	return &upvalue
+}
+
+func NewClass(name string) *LoxClass {
+	return &LoxClass{name: name, methods: make(map[string]Value), shape: newShape(), shapes: 1}
+}
+
+func (klass *LoxClass) defineMethod(name string, method Value) {
+	klass.methods[name] = method
+	klass.version++
+}
+
+func (klass *LoxClass) inherit(superKlass *LoxClass) {
+	tableAddAll(superKlass.methods, klass.methods)
+	klass.version++
+}
+
+func NewInstance(klass *LoxClass) *LoxInstance {
+	return &LoxInstance{klass: klass, shape: klass.shape}
+}
+
+func NewBoundMethod(receiver Value, method *LoxClosure) *BoundMethod {
+	return &BoundMethod{receiver: receiver, method: method}
+}
+
+func isSameType(v1 *Value, v2 *Value) bool {
+	return v1.vtype == v2.vtype
+}
+
+// Set stores a Go value: nil, a bool, an int or float64, a string, or one of
+// the object types. Anything else panics.
+func (v *Value) Set(value interface{}) {
+	switch value := value.(type) {
+	case nil:
+		*v = NilVal()
+	case bool:
+		*v = BoolVal(value)
+	case int:
+		*v = IntVal(value)
+	case float64:
+		*v = FloatVal(value)
+	case string:
+		*v = StringVal(value)
+	case *LoxFunction:
+		*v = FunctionVal(value)
+	case NativeFn:
+		*v = NativeVal(value)
+	case *LoxClosure:
+		*v = ClosureVal(value)
+	case *LoxClass:
+		*v = ClassVal(value)
+	case *LoxInstance:
+		*v = InstanceVal(value)
+	case *BoundMethod:
+		*v = BoundMethodVal(value)
+	default:
+		panic(fmt.Sprintf("lox: no Value for %T", value))
+	}
+}
+
+// Get returns the value as nil, a bool, a float64, a string or an object.
+func (v *Value) Get() interface{} {
+	switch v.vtype {
+	case VAL_NIL:
+		return nil
+	case VAL_BOOL:
+		return v.number != 0
+	case VAL_NUMBER:
+		return v.number
+	default:
+		return v.obj
+	}
+}
+
+func NilVal() Value {
+	return Value{vtype: VAL_NIL}
+}
+
+// IntVal is a number; Lox has no separate integer type.
+func IntVal(v int) Value {
+	return Value{vtype: VAL_NUMBER, number: float64(v)}
+}
+
+func FloatVal(v float64) Value {
+	return Value{vtype: VAL_NUMBER, number: v}
+}
+
+func StringVal(v string) Value {
+	return Value{vtype: VAL_STRING, obj: v}
+}
+
+func BoolVal(v bool) Value {
+	if v {
+		return Value{vtype: VAL_BOOL, number: 1}
+	}
+	return Value{vtype: VAL_BOOL}
+}
+
+func FunctionVal(function *LoxFunction) Value {
+	return Value{vtype: VAL_FUNCTION, obj: function}
+}
+
+func NativeVal(function NativeFn) Value {
+	return Value{vtype: VAL_NATIVE, obj: function}
+}
+
+func ClosureVal(closure *LoxClosure) Value {
+	return Value{vtype: VAL_CLOSURE, obj: closure}
+}
+
+func ClassVal(class *LoxClass) Value {
+	return Value{vtype: VAL_CLASS, obj: class}
+}
+
+func InstanceVal(instance *LoxInstance) Value {
+	return Value{vtype: VAL_INSTANCE, obj: instance}
+}
+
+func BoundMethodVal(boundMethod *BoundMethod) Value {
+	return Value{vtype: VAL_BOUND_METHOD, obj: boundMethod}
+}
+
+func (v Value) IsNil() bool {
+	return v.vtype == VAL_NIL
+}
+
+// IsInt reports whether v is a number with no fractional part.
+func (v Value) IsInt() bool {
+	return v.vtype == VAL_NUMBER && v.number == math.Trunc(v.number) && !math.IsInf(v.number, 0)
+}
+
+func (v Value) IsFloat() bool {
+	return v.vtype == VAL_NUMBER
+}
+
+func (v Value) IsString() bool {
+	return v.vtype == VAL_STRING
+}
+
+func (v Value) IsBool() bool {
+	return v.vtype == VAL_BOOL
+}
+
+func (v Value) IsFunction() bool {
+	return v.vtype == VAL_FUNCTION
+}
+
+func (v Value) IsNative() bool {
+	return v.vtype == VAL_NATIVE
+}
+
+func (v Value) IsClosure() bool {
+	return v.vtype == VAL_CLOSURE
+}
+
+func (v Value) IsClass() bool {
+	return v.vtype == VAL_CLASS
+}
+
+func (v Value) IsInstance() bool {
+	return v.vtype == VAL_INSTANCE
+}
+
+func (v Value) IsBoundMethod() bool {
+	return v.vtype == VAL_BOUND_METHOD
+}
+
+func (v Value) GetInt() (int, bool) {
+	if !v.IsInt() {
+		return 0, false
+	}
+	return int(v.number), true
+}
+
+func (v Value) GetFloat() (float64, bool) {
+	if v.vtype != VAL_NUMBER {
+		return 0.0, false
+	}
+	return v.number, true
+}
+
+func (v Value) GetString() (string, bool) {
+	if v.vtype != VAL_STRING {
+		return "", false
+	}
+	return v.obj.(string), true
+}
+
+func (v Value) GetBool() (bool, bool) {
+	if v.vtype != VAL_BOOL {
+		return false, false
+	}
+	return v.number != 0, true
+}
+
+func (v Value) GetFunction() (*LoxFunction, bool) {
+	if v.vtype != VAL_FUNCTION {
+		return nil, false
+	}
+	return v.obj.(*LoxFunction), true
+}
+
+func (v Value) GetNative() (NativeFn, bool) {
+	if v.vtype != VAL_NATIVE {
+		return nil, false
+	}
+	return v.obj.(NativeFn), true
+}
+
+func (v Value) GetClosure() (*LoxClosure, bool) {
+	if v.vtype != VAL_CLOSURE {
+		return nil, false
+	}
+	return v.obj.(*LoxClosure), true
+}
+
+func (v Value) GetClass() (*LoxClass, bool) {
+	if v.vtype != VAL_CLASS {
+		return nil, false
+	}
+	return v.obj.(*LoxClass), true
+}
+
+func (v Value) GetInstance() (*LoxInstance, bool) {
+	if v.vtype != VAL_INSTANCE {
+		return nil, false
+	}
+	return v.obj.(*LoxInstance), true
+}
+
+func (v Value) GetBoundMethod() (*BoundMethod, bool) {
+	if v.vtype != VAL_BOUND_METHOD {
+		return nil, false
+	}
+	return v.obj.(*BoundMethod), true
+}
+
+func (v *Value) SetNil() {
+	*v = NilVal()
+}
+
+func (v *Value) SetInt(value int) {
+	*v = IntVal(value)
+}
+
+func (v *Value) SetFloat(value float64) {
+	*v = FloatVal(value)
+}
+
+func (v *Value) SetString(value string) {
+	*v = StringVal(value)
+}
+
+func (v *Value) SetBool(value bool) {
+	*v = BoolVal(value)
+}
+
+func IsValueEqual(v1, v2 *Value) bool {
+	if !isSameType(v1, v2) {
+		return false
+	}
+
+	switch v1.vtype {
+	case VAL_NIL:
+		return true
+	case VAL_BOOL, VAL_NUMBER:
+		return v1.number == v2.number
+	case VAL_STRING:
+		return v1.obj.(string) == v2.obj.(string)
+	case VAL_NATIVE:
+		// functions aren't comparable in Go, so compare their code pointers
+		return reflect.ValueOf(v1.obj).Pointer() == reflect.ValueOf(v2.obj).Pointer()
+	}
+
+	// objects are equal only to themselves
+	return v1.obj == v2.obj
+}
+
+// TypeName is the Lox-level name of the value's type.
+func (v Value) TypeName() string {
+	switch v.vtype {
+	case VAL_NIL:
+		return "nil"
+	case VAL_BOOL:
+		return "bool"
+	case VAL_NUMBER:
+		return "number"
+	case VAL_STRING:
+		return "string"
+	case VAL_FUNCTION, VAL_CLOSURE:
+		return "function"
+	case VAL_NATIVE:
+		return "native"
+	case VAL_CLASS:
+		return "class"
+	case VAL_INSTANCE:
+		return "instance"
+	case VAL_BOUND_METHOD:
+		return "bound method"
+	default:
+		return "unknown"
+	}
+}
+
+func (v Value) String() string {
+	switch v.vtype {
+	case VAL_NIL:
+		return "nil"
+	case VAL_BOOL:
+		result, _ := v.GetBool()
+		return fmt.Sprintf("%t", result)
+	case VAL_NUMBER:
+		return formatNumber(v.number)
+	case VAL_STRING:
+		result, _ := v.GetString()
+		return result
+	case VAL_FUNCTION:
+		function, _ := v.GetFunction()
+		return NormalizedFuncName(function.name)
+	case VAL_CLOSURE:
+		closure, _ := v.GetClosure()
+		return NormalizedFuncName(closure.function.name)
+	case VAL_NATIVE:
+		return "<native fn>"
+	case VAL_CLASS:
+		klass, _ := v.GetClass()
+		return klass.name
+	case VAL_INSTANCE:
+		instance, _ := v.GetInstance()
+		return instance.klass.name + " instance"
+	case VAL_BOUND_METHOD:
+		boundMethod, _ := v.GetBoundMethod()
+		return NormalizedFuncName(boundMethod.method.function.name)
+	default:
+		return "unknown"
+	}
+}
+
+func NormalizedFuncName(name string) string {
+	if name == "" {
+		return "<script>"
+	}
+	return "<fn " + name + ">"
+}
+
+// formatNumber prints n the way clox's printf("%g") does: six significant
+// digits, no trailing zeros, and C's spelling of infinities and NaN.
+func formatNumber(n float64) string {
+	sign := ""
+	if math.Signbit(n) {
+		sign = "-"
+	}
+	switch {
+	case math.IsNaN(n):
+		return sign + "nan"
+	case math.IsInf(n, 0):
+		return sign + "inf"
+	}
+	return strconv.FormatFloat(n, 'g', 6, 64)
+}
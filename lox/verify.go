@@ -0,0 +1,304 @@
+package lox
+
+import (
+	"fmt"
+	"math"
+)
+
+// VerifyError describes the first problem Verify found in a chunk.
+type VerifyError struct {
+	Function string
+	Offset   int
+	Message  string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("%v: %s at %04d: %s", ErrBadBytecode, NormalizedFuncName(e.Function), e.Offset, e.Message)
+}
+
+func (e *VerifyError) Unwrap() error {
+	return ErrBadBytecode
+}
+
+// Verify checks a compiled script and every function nested in it before
+// the VM trusts it: each instruction must decode inside the chunk, constant,
+// global, local, upvalue and inline cache operands must be in range and of the right kind, jumps
+// must land on instruction boundaries, execution must never run off the end
+// of the code, and every path must agree on the stack depth. The maximum
+// depth found is recorded so the VM can refuse calls that would overflow.
+func Verify(function *LoxFunction) error {
+	if function.upValueCount != 0 {
+		return &VerifyError{function.name, 0, "top-level script can't capture upvalues"}
+	}
+	return verifyFunction(function)
+}
+
+type verifier struct {
+	function *LoxFunction
+	chunk    *Chunk
+	depth    []int // stack depth on entry to each instruction, -1 if not reached yet
+}
+
+func (v *verifier) fail(offset int, format string, args ...interface{}) error {
+	return &VerifyError{v.function.name, offset, fmt.Sprintf(format, args...)}
+}
+
+func verifyFunction(function *LoxFunction) error {
+	v := &verifier{function: function, chunk: &function.chunk}
+	if function.arity > math.MaxUint8 {
+		return v.fail(0, "arity %d is out of range", function.arity)
+	}
+	if function.upValueCount > MAX_UPVALUES {
+		return v.fail(0, "upvalue count %d is out of range", function.upValueCount)
+	}
+	if err := v.positions(); err != nil {
+		return err
+	}
+	if err := v.decode(); err != nil {
+		return err
+	}
+	if err := v.flow(); err != nil {
+		return err
+	}
+	for _, constant := range v.chunk.constants {
+		if nested, ok := constant.GetFunction(); ok {
+			if err := verifyFunction(nested); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// positions checks that the position runs start at the first byte and
+// each starts inside the code, after the one before it.
+func (v *verifier) positions() error {
+	for i, run := range v.chunk.positions {
+		if (i == 0 && run.start != 0) || (i > 0 && run.start <= v.chunk.positions[i-1].start) || run.start >= len(v.chunk.bcodes) {
+			return v.fail(run.start, "bad position table")
+		}
+	}
+	if len(v.chunk.positions) == 0 && len(v.chunk.bcodes) > 0 {
+		return v.fail(0, "missing position table")
+	}
+	return nil
+}
+
+// decode walks the code linearly, checking that every instruction and its
+// operands fit, and marks instruction starts as valid jump targets.
+func (v *verifier) decode() error {
+	v.depth = make([]int, len(v.chunk.bcodes))
+	for i := range v.depth {
+		v.depth[i] = -2 // not an instruction boundary
+	}
+	for offset := 0; offset < len(v.chunk.bcodes); {
+		v.depth[offset] = -1
+		length, err := v.instructionLength(offset)
+		if err != nil {
+			return err
+		}
+		offset += length
+	}
+	return nil
+}
+
+func (v *verifier) instructionLength(offset int) (int, error) {
+	code := v.chunk.bcodes
+	info, ok := LookupOp(code[offset])
+	if !ok {
+		return 0, v.fail(offset, "unknown opcode %d", code[offset])
+	}
+	length := 1 + operandWidth(info.Operand) + cacheWidth(info.Operand)
+	switch info.Operand {
+	case OPERAND_INVOKE, OPERAND_INVOKE_LONG:
+		length++
+	case OPERAND_CLOSURE, OPERAND_CLOSURE_LONG:
+		if offset+length > len(code) {
+			return 0, v.fail(offset, "%s operand runs past end of code", info.Name)
+		}
+		nested, err := v.functionConstant(offset, v.chunk.readIndex(offset+1, length-1))
+		if err != nil {
+			return 0, err
+		}
+		for range nested.upValueCount {
+			if offset+length >= len(code) {
+				return 0, v.fail(offset, "%s operand runs past end of code", info.Name)
+			}
+			if code[offset+length]&UPVALUE_WIDE != 0 {
+				length += 4
+			} else {
+				length += 2
+			}
+		}
+	}
+	if offset+length > len(code) {
+		return 0, v.fail(offset, "%s operand runs past end of code", info.Name)
+	}
+	return length, nil
+}
+
+func (v *verifier) constant(offset int, index int) (Value, error) {
+	if index >= len(v.chunk.constants) {
+		return NilVal(), v.fail(offset, "constant index %d out of range (%d constants)", index, len(v.chunk.constants))
+	}
+	return v.chunk.constants[index], nil
+}
+
+func (v *verifier) functionConstant(offset int, index int) (*LoxFunction, error) {
+	value, err := v.constant(offset, index)
+	if err != nil {
+		return nil, err
+	}
+	nested, ok := value.GetFunction()
+	if !ok {
+		return nil, v.fail(offset, "constant %d is a %s, expected a function", index, value.TypeName())
+	}
+	return nested, nil
+}
+
+func (v *verifier) nameConstant(offset int, index int) error {
+	value, err := v.constant(offset, index)
+	if err != nil {
+		return err
+	}
+	if !value.IsString() {
+		return v.fail(offset, "constant %d is a %s, expected a name", index, value.TypeName())
+	}
+	return nil
+}
+
+// flow follows every path through the code from the entry point, tracking
+// the stack depth relative to the frame's first slot.
+func (v *verifier) flow() error {
+	type state struct{ offset, depth int }
+	entry := v.function.arity + 1 // callee or receiver, then the parameters
+	if len(v.chunk.bcodes) == 0 {
+		return v.fail(0, "empty code")
+	}
+	maxStack := entry
+	work := []state{{0, entry}}
+	for len(work) > 0 {
+		s := work[len(work)-1]
+		work = work[:len(work)-1]
+		for {
+			if s.offset >= len(v.chunk.bcodes) {
+				return v.fail(s.offset, "execution runs past end of code")
+			}
+			if v.depth[s.offset] == -2 {
+				return v.fail(s.offset, "jump into the middle of an instruction")
+			}
+			if v.depth[s.offset] >= 0 {
+				if v.depth[s.offset] != s.depth {
+					return v.fail(s.offset, "stack depth %d here, %d on another path", s.depth, v.depth[s.offset])
+				}
+				break
+			}
+			v.depth[s.offset] = s.depth
+
+			next, targets, depth, err := v.step(s.offset, s.depth)
+			if err != nil {
+				return err
+			}
+			maxStack = max(maxStack, depth)
+			for _, target := range targets {
+				work = append(work, state{target, depth})
+			}
+			if next < 0 {
+				break
+			}
+			s = state{next, depth}
+		}
+	}
+	v.function.maxStack = maxStack
+	return nil
+}
+
+// step checks the instruction at offset entered with the given stack depth.
+// It returns the fall-through offset (-1 if control never falls through),
+// any jump targets, and the depth after the instruction.
+func (v *verifier) step(offset int, depth int) (int, []int, int, error) {
+	code := v.chunk.bcodes
+	op := code[offset]
+	info, _ := LookupOp(op)
+	length, _ := v.instructionLength(offset)
+	next := offset + length
+	var targets []int
+	pops := info.pops
+	width := constantWidth(info.Operand)
+	if cacheWidth(info.Operand) > 0 {
+		if cache := v.chunk.readIndex(offset+1+width, cacheWidth(info.Operand)); cache >= v.chunk.caches {
+			return 0, nil, 0, v.fail(offset, "inline cache %d out of range (%d caches)", cache, v.chunk.caches)
+		}
+	}
+
+	switch info.Operand {
+	case OPERAND_CONSTANT, OPERAND_CONSTANT_LONG, OPERAND_PROPERTY, OPERAND_PROPERTY_LONG:
+		index := v.chunk.readIndex(offset+1, width)
+		if op == OP_CONSTANT || op == OP_CONSTANT_LONG {
+			if _, err := v.constant(offset, index); err != nil {
+				return 0, nil, 0, err
+			}
+		} else if err := v.nameConstant(offset, index); err != nil {
+			return 0, nil, 0, err
+		}
+	case OPERAND_GLOBAL, OPERAND_GLOBAL_LONG:
+		if slot := v.chunk.readIndex(offset+1, operandWidth(info.Operand)); slot >= len(v.chunk.globals) {
+			return 0, nil, 0, v.fail(offset, "global slot %d out of range (%d globals)", slot, len(v.chunk.globals))
+		}
+	case OPERAND_LOCAL, OPERAND_LOCAL_LONG:
+		if slot := v.chunk.readIndex(offset+1, operandWidth(info.Operand)); slot >= depth-pops {
+			return 0, nil, 0, v.fail(offset, "local slot %d out of range (stack depth %d)", slot, depth)
+		}
+	case OPERAND_UPVALUE, OPERAND_UPVALUE_LONG:
+		if index := v.chunk.readIndex(offset+1, operandWidth(info.Operand)); index >= v.function.upValueCount {
+			return 0, nil, 0, v.fail(offset, "upvalue %d out of range (%d upvalues)", index, v.function.upValueCount)
+		}
+	case OPERAND_ARGC:
+		pops += int(code[offset+1])
+	case OPERAND_INVOKE, OPERAND_INVOKE_LONG:
+		if err := v.nameConstant(offset, v.chunk.readIndex(offset+1, width)); err != nil {
+			return 0, nil, 0, err
+		}
+		pops += int(code[offset+1+width+cacheWidth(info.Operand)])
+	case OPERAND_JUMP, OPERAND_LOOP, OPERAND_JUMP_LONG, OPERAND_LOOP_LONG:
+		jump := v.chunk.readIndex(offset+1, operandWidth(info.Operand))
+		target := next + jump
+		if info.Operand == OPERAND_LOOP || info.Operand == OPERAND_LOOP_LONG {
+			target = next - jump
+		}
+		if target < 0 || target >= len(code) {
+			return 0, nil, 0, v.fail(offset, "jump target %d outside of code", target)
+		}
+		if op != OP_JUMP_IF_FALSE && op != OP_JUMP_IF_FALSE_LONG {
+			next = target
+		} else {
+			targets = append(targets, target)
+		}
+	case OPERAND_CLOSURE, OPERAND_CLOSURE_LONG:
+		nested, _ := v.functionConstant(offset, v.chunk.readIndex(offset+1, width))
+		descriptor := offset + 1 + width
+		for range nested.upValueCount {
+			flags := code[descriptor]
+			isLocal, index, next := v.chunk.upvalueDescriptor(descriptor)
+			switch {
+			case flags&^(UPVALUE_LOCAL|UPVALUE_WIDE) != 0:
+				return 0, nil, 0, v.fail(descriptor, "bad upvalue kind %d", flags)
+			case isLocal && index > depth: // a local function may capture the slot it is stored in
+				return 0, nil, 0, v.fail(descriptor, "captured local slot %d out of range (stack depth %d)", index, depth)
+			case !isLocal && index >= v.function.upValueCount:
+				return 0, nil, 0, v.fail(descriptor, "captured upvalue %d out of range (%d upvalues)", index, v.function.upValueCount)
+			}
+			descriptor = next
+		}
+	}
+
+	// slot 0 holds the frame's callee and must never be popped
+	if depth-pops < 1 {
+		return 0, nil, 0, v.fail(offset, "%s pops %d values with stack depth %d", info.Name, pops, depth)
+	}
+	depth += info.pushes - pops
+	if op == OP_RETURN {
+		next = -1
+	}
+	return next, targets, depth, nil
+}
@@ -0,0 +1,94 @@
+package lox
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestVerifyAcceptsCompiledTestcases(t *testing.T) {
+	for path, source := range loadTestcases(t) {
+		function, err := Compile(source, Config{})
+		if err != nil {
+			continue
+		}
+		if err := Verify(function); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+}
+
+func TestVerifyRejectsMalformedCode(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      []byte
+		constants []Value
+		want      string
+	}{
+		{"unknown opcode", []byte{0xEE}, nil, "unknown opcode"},
+		{"truncated operand", []byte{OP_CONSTANT}, nil, "runs past end"},
+		{"constant out of range", []byte{OP_CONSTANT, 3, OP_RETURN}, nil, "constant index 3"},
+		{"class name not a string", []byte{OP_CLASS, 0, OP_RETURN}, []Value{FloatVal(1)}, "expected a name"},
+		{"global out of range", []byte{OP_GET_GLOBAL, 0, OP_RETURN}, nil, "global slot 0"},
+		{"inline cache out of range", []byte{OP_NIL, OP_GET_PROPERTY, 0, 0, 5, OP_RETURN}, []Value{StringVal("x")}, "inline cache 5"},
+		{"local out of range", []byte{OP_GET_LOCAL, 7, OP_RETURN}, nil, "local slot 7"},
+		{"upvalue out of range", []byte{OP_GET_UPVALUE, 0, OP_RETURN}, nil, "upvalue 0"},
+		{"jump into operand", []byte{OP_JUMP, 0, 1, OP_CONSTANT, 0, OP_NIL, OP_RETURN}, []Value{NilVal()}, "middle of an instruction"},
+		{"jump outside code", []byte{OP_JUMP, 0, 9, OP_NIL, OP_RETURN}, nil, "outside of code"},
+		{"stack underflow", []byte{OP_POP, OP_NIL, OP_RETURN}, nil, "pops 1 values"},
+		{"falls off the end", []byte{OP_NIL, OP_POP}, nil, "past end of code"},
+		{"unbalanced branches", []byte{OP_TRUE, OP_JUMP_IF_FALSE, 0, 1, OP_NIL, OP_NIL, OP_RETURN}, nil, "stack depth"},
+		{"wide local out of range", []byte{OP_GET_LOCAL_LONG, 0, 1, 0, OP_RETURN}, nil, "local slot 256"},
+		{"long jump outside code", []byte{OP_JUMP_LONG, 0, 1, 0, 0, OP_NIL, OP_RETURN}, nil, "outside of code"},
+	}
+	for _, test := range tests {
+		function := NewFunction()
+		function.chunk.bcodes = test.code
+		function.chunk.positions = []positionRun{{0, Position{Line: 1, Column: 1}}}
+		function.chunk.constants = test.constants
+		err := Verify(function)
+		if !errors.Is(err, ErrBadBytecode) || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want error containing %q", test.name, err, test.want)
+		}
+	}
+}
+
+// Verify only checks the shape of the code, so code that passes it but puts
+// the wrong kind of value on the stack must fail at runtime, not crash.
+func TestVerifiedCodeFailsSafely(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		from   byte
+		nth    int // which occurrence of from to replace by OP_CONSTANT
+		want   string
+	}{
+		{"method on a non-class", "class A { m() {} }", OP_CLASS, 0, "Only classes have methods."},
+		{"method not a closure", "class A { m() {} } A().m();", OP_CLOSURE, 0, "Methods must be closures."},
+		{"subclass not a class", "class A {} class B < A {}", OP_CLASS, 1, "Subclass must be a class."},
+	}
+	for _, test := range tests {
+		function, err := Compile(test.source, Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunk := &function.chunk
+		for offset, seen := 0, 0; offset < len(chunk.bcodes); offset = DisassembleInstruction(io.Discard, chunk, offset) {
+			if chunk.bcodes[offset] == test.from {
+				if seen == test.nth {
+					chunk.bcodes[offset] = OP_CONSTANT
+				}
+				seen++
+			}
+		}
+		if err := Verify(function); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		err = NewVM(Config{Stdout: io.Discard}).Interpret(function)
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) || runtimeErr.Message != test.want {
+			t.Errorf("%s: got %v, want runtime error %q", test.name, err, test.want)
+		}
+	}
+}
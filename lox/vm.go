@@ -0,0 +1,734 @@
+// Package lox implements the glox scanner, bytecode compiler and virtual machine.
+package lox
+
+import (
+	"fmt"
+	"iter"
+	"maps"
+	"math"
+	"slices"
+	"time"
+)
+
+const (
+	FRAMES_MAX     int = iota + 64
+	VSTACK_INITIAL int = FRAMES_MAX * math.MaxUint8 // slots a VM starts with, call grows the stack past it
+)
+
+type CallFrame struct {
+	closure    *LoxClosure
+	ip         int
+	slots_base int
+}
+
+type VM struct {
+	frames       [FRAMES_MAX]CallFrame
+	frameCount   int
+	vstack       []Value
+	vstackCount  int
+	globals      []globalVar    // every global the VM has seen, defined or not
+	globalNames  []string       // name of each slot in globals
+	globalSlots  map[string]int // slot of each name in globals
+	openUpvalues *UpvalueObj
+	config       Config
+	startTime    time.Time
+	err          error // *RuntimeError or *ExitError that stopped runVM
+	steps        int   // instructions executed by the current Interpret call
+}
+
+type globalVar struct {
+	value   Value
+	defined bool
+}
+
+func isfalsey(value Value) bool {
+	if value.IsNil() {
+		return true
+	}
+	if value.IsBool() {
+		v, _ := value.GetBool()
+		return !v
+	}
+	return false
+}
+
+func tableSet(table map[string]Value, name string, value Value) bool {
+	_, ok := table[name]
+	table[name] = value
+	return !ok
+}
+
+func tableGet(table map[string]Value, name string) (Value, bool) {
+	value, ok := table[name]
+	return value, ok
+}
+
+func tableDelete(table map[string]Value, name string) {
+	delete(table, name)
+}
+
+func tableAddAll(srcTable map[string]Value, dstTable map[string]Value) {
+	maps.Copy(dstTable, srcTable)
+}
+
+func (frame *CallFrame) readByte() byte {
+	data := frame.closure.function.chunk.bcodes[frame.ip]
+	frame.ip++
+	return data
+}
+
+func (frame *CallFrame) readShort() uint16 {
+	result := uint16(frame.closure.function.chunk.bcodes[frame.ip])<<8 + uint16(frame.closure.function.chunk.bcodes[frame.ip+1])
+	frame.ip += 2
+	return result
+}
+
+// readIndexOf reads the slot, upvalue or constant index operand of
+// instruction, which is either a short form or its _LONG variant.
+func (frame *CallFrame) readIndexOf(instruction byte) int {
+	if instruction >= OP_CONSTANT_LONG {
+		index := frame.closure.function.chunk.readIndex(frame.ip, 3)
+		frame.ip += 3
+		return index
+	}
+	return int(frame.readByte())
+}
+
+// readJumpOf reads the 16-bit offset of a jump, or the 32-bit offset of its
+// _LONG variant.
+func (frame *CallFrame) readJumpOf(instruction byte) int {
+	if instruction >= OP_CONSTANT_LONG {
+		offset := frame.closure.function.chunk.readIndex(frame.ip, 4)
+		frame.ip += 4
+		return offset
+	}
+	return int(frame.readShort())
+}
+
+// readCacheOf reads the inline cache slot operand of instruction and
+// returns the cache it refers to.
+func (frame *CallFrame) readCacheOf(instruction byte) *methodCache {
+	var slot int
+	if instruction >= OP_CONSTANT_LONG {
+		slot = frame.closure.function.chunk.readIndex(frame.ip, 3)
+		frame.ip += 3
+	} else {
+		slot = int(frame.readShort())
+	}
+	return &frame.closure.caches[slot]
+}
+
+func (frame *CallFrame) readConstant() Value {
+	pos := frame.readByte()
+	return frame.closure.function.chunk.constants[pos]
+}
+
+func (frame *CallFrame) readConstantLong() Value {
+	chunk := &frame.closure.function.chunk
+	pos := chunk.readIndex(frame.ip, 3)
+	frame.ip += 3
+	return chunk.constants[pos]
+}
+
+// readConstantOf reads the constant index operand of instruction, which is
+// either a short form or its _LONG variant.
+func (frame *CallFrame) readConstantOf(instruction byte) Value {
+	if instruction >= OP_CONSTANT_LONG {
+		return frame.readConstantLong()
+	}
+	return frame.readConstant()
+}
+
+func (vm *VM) pushVstack(value Value) {
+	vm.vstack[vm.vstackCount] = value
+	vm.vstackCount++
+}
+
+func (vm *VM) popVstack() Value {
+	value := vm.vstack[vm.vstackCount-1]
+	vm.vstackCount--
+	return value
+}
+
+func (vm *VM) peekVstack(offset int) Value {
+	return vm.vstack[vm.vstackCount-1-offset]
+}
+
+func (vm *VM) resetStack() {
+	vm.vstackCount = 0
+	if vm.vstack == nil {
+		vm.vstack = make([]Value, VSTACK_INITIAL)
+	}
+	clear(vm.vstack)
+	vm.frameCount = 0
+	vm.frames = [FRAMES_MAX]CallFrame{}
+	vm.openUpvalues = nil
+}
+
+// growStack makes room for at least size slots. Open upvalues point into the
+// stack, so they are moved along with it.
+func (vm *VM) growStack(size int) {
+	stack := make([]Value, max(size, 2*len(vm.vstack)))
+	copy(stack, vm.vstack[:vm.vstackCount])
+	vm.vstack = stack
+	for upvalue := vm.openUpvalues; upvalue != nil; upvalue = upvalue.next {
+		upvalue.ref = &vm.vstack[upvalue.location]
+	}
+}
+
+func (vm *VM) call(closure *LoxClosure, argCount int) bool {
+	function := closure.function
+	if argCount != function.arity {
+		vm.runtimeError("Expected %d arguments but got %d.", function.arity, argCount)
+		return false
+	}
+	if vm.frameCount == FRAMES_MAX {
+		vm.runtimeError("Stack overflow.")
+		return false
+	}
+	if size := vm.vstackCount - argCount - 1 + function.maxStack; size > len(vm.vstack) {
+		vm.growStack(size)
+	}
+	frame := &vm.frames[vm.frameCount]
+	vm.frameCount++
+	frame.closure = closure
+	frame.ip = 0
+	frame.slots_base = vm.vstackCount - argCount - 1
+	return true
+}
+
+func (vm *VM) callValue(callee Value, argCount int) bool {
+	if callee.IsClosure() {
+		closure, _ := callee.GetClosure()
+		return vm.call(closure, argCount)
+	} else if callee.IsClass() {
+		klass, _ := callee.GetClass()
+		instance := NewInstance(klass)
+		vm.vstack[vm.vstackCount-argCount-1] = InstanceVal(instance)
+		initializer, ok := tableGet(klass.methods, "init")
+		if ok {
+			closure, _ := initializer.GetClosure()
+			return vm.call(closure, argCount)
+		}
+		if argCount != 0 {
+			vm.runtimeError("Expected 0 arguments but got %d.", argCount)
+			return false
+		}
+		return true
+	} else if callee.IsBoundMethod() {
+		boundMethod, _ := callee.GetBoundMethod()
+		vm.vstack[vm.vstackCount-argCount-1] = boundMethod.receiver
+		return vm.call(boundMethod.method, argCount)
+	} else if callee.IsNative() {
+		native, _ := callee.GetNative()
+		result, ok := native(vm, argCount, vm.vstack[vm.vstackCount-argCount:vm.vstackCount])
+		if !ok {
+			return false
+		}
+		vm.vstackCount -= argCount + 1
+		vm.pushVstack(result)
+		return true
+	}
+	vm.runtimeError("Can only call functions and classes.")
+	return false
+}
+
+// local is vstack absolutely offset
+func (vm *VM) CaptureUpvalue(value *Value, local int) *UpvalueObj {
+	var previousUpvalue *UpvalueObj = nil
+	var currentUpvalue *UpvalueObj = vm.openUpvalues
+	for currentUpvalue != nil && currentUpvalue.location > local {
+		previousUpvalue = currentUpvalue
+		currentUpvalue = currentUpvalue.next
+	}
+
+	if currentUpvalue != nil && currentUpvalue.location == local {
+		return currentUpvalue
+	}
+	createdUpvalue := NewUpvalueObj(value, local)
+	createdUpvalue.next = currentUpvalue
+	if previousUpvalue == nil {
+		vm.openUpvalues = createdUpvalue
+	} else {
+		previousUpvalue.next = createdUpvalue
+	}
+	return createdUpvalue
+}
+
+func (vm *VM) closeUpvalues(last int) {
+	for vm.openUpvalues != nil && vm.openUpvalues.location >= last {
+		upvalue := vm.openUpvalues
+		upvalue.closed = *upvalue.ref
+		upvalue.ref = &upvalue.closed
+		vm.openUpvalues = upvalue.next
+	}
+}
+
+// methodCache is the inline cache of one property, invoke or super
+// instruction: the method it found last and the class it found it in.
+type methodCache struct {
+	klass   *LoxClass
+	version int
+	method  *LoxClosure
+}
+
+// findMethod looks name up in the methods of klass, skipping the method table
+// while the instruction keeps seeing the same, unchanged class.
+func findMethod(klass *LoxClass, name string, cache *methodCache) (*LoxClosure, bool) {
+	if cache.klass == klass && cache.version == klass.version {
+		return cache.method, true
+	}
+	val, ok := tableGet(klass.methods, name)
+	if !ok {
+		return nil, false
+	}
+	method, _ := val.GetClosure()
+	*cache = methodCache{klass, klass.version, method}
+	return method, true
+}
+
+func (vm *VM) bindMethod(klass *LoxClass, name string, cache *methodCache) bool {
+	method, ok := findMethod(klass, name, cache)
+	if !ok {
+		return false
+	}
+	boundMethod := NewBoundMethod(vm.peekVstack(0), method)
+	vm.popVstack() // pop instance value
+	vm.pushVstack(BoundMethodVal(boundMethod))
+	return true
+}
+
+func (vm *VM) invoke(methodName string, argCount int, cache *methodCache) bool {
+	instance, isInstance := vm.peekVstack(argCount).GetInstance()
+	if !isInstance {
+		vm.runtimeError("Only instances have methods.")
+		return false
+	}
+	fieldVal, hasField := instance.getField(methodName)
+	if hasField {
+		vm.vstack[vm.vstackCount-argCount-1] = fieldVal
+		return vm.callValue(fieldVal, argCount)
+	}
+	if closure, hasMethod := findMethod(instance.klass, methodName, cache); hasMethod {
+		return vm.call(closure, int(argCount))
+	}
+	vm.runtimeError("Undefined property '%s'.", methodName)
+	vm.suggestFrom(methodName, instance.fieldNames(), maps.Keys(instance.klass.methods))
+	return false
+}
+
+func (vm *VM) invokeFromClass(klass *LoxClass, methodName string, argCount int, cache *methodCache) bool {
+	if closure, hasMethod := findMethod(klass, methodName, cache); hasMethod {
+		return vm.call(closure, int(argCount))
+	}
+	vm.runtimeError("Undefined property '%s'.", methodName)
+	vm.suggest(methodName, klass.methods)
+	return false
+}
+
+func (vm *VM) runtimeError(format string, args ...interface{}) {
+	err := &RuntimeError{Message: fmt.Sprintf(format, args...)}
+	for i := vm.frameCount - 1; i >= 0; i-- {
+		frame := &vm.frames[i]
+		function := frame.closure.function
+		// ip already points past the failing instruction
+		pos := function.chunk.Position(max(frame.ip-1, 0))
+		err.Trace = append(err.Trace, StackFrame{Function: function.name, Position: pos})
+	}
+	vm.err = err
+
+	vm.resetStack()
+}
+
+// Exit stops the running script. Interpret then returns an *ExitError with
+// the given status instead of terminating the process.
+func (vm *VM) Exit(code int) {
+	vm.err = &ExitError{Code: code}
+	vm.resetStack()
+}
+
+func (vm *VM) runVM() bool {
+	frame := &vm.frames[vm.frameCount-1]
+
+	for {
+		if frame.ip >= len(frame.closure.function.chunk.bcodes) {
+			break
+		}
+		if vm.config.Trace {
+			DebugVM(vm)
+		}
+		if vm.config.MaxSteps > 0 {
+			if vm.steps == vm.config.MaxSteps {
+				vm.runtimeError("Instruction budget of %d exhausted.", vm.config.MaxSteps)
+				return false
+			}
+			vm.steps++
+		}
+
+		instruction := frame.readByte()
+
+		switch instruction {
+		case OP_CONSTANT, OP_CONSTANT_LONG:
+			vm.pushVstack(frame.readConstantOf(instruction))
+		case OP_NIL:
+			vm.pushVstack(NilVal())
+		case OP_FALSE:
+			vm.pushVstack(BoolVal(false))
+		case OP_TRUE:
+			vm.pushVstack(BoolVal(true))
+		case OP_NOT:
+			vm.pushVstack(BoolVal(isfalsey(vm.popVstack())))
+		case OP_NEGATE:
+			if !(vm.peekVstack(0).IsFloat()) {
+				vm.runtimeError("Operand must be a number.")
+				return false
+			}
+			value := vm.popVstack()
+			tmp, _ := value.GetFloat()
+			vm.pushVstack(FloatVal(-tmp))
+		case OP_EQUAL:
+			right := vm.popVstack()
+			left := vm.popVstack()
+			vm.pushVstack(BoolVal(IsValueEqual(&left, &right)))
+		case OP_GREATER:
+			if vm.peekVstack(0).IsFloat() && vm.peekVstack(1).IsFloat() {
+				right, _ := vm.popVstack().GetFloat()
+				left, _ := vm.popVstack().GetFloat()
+				vm.pushVstack(BoolVal(left > right))
+			} else {
+				vm.runtimeError("Operands must be numbers.")
+				return false
+			}
+		case OP_LESS:
+			if vm.peekVstack(0).IsFloat() && vm.peekVstack(1).IsFloat() {
+				right, _ := vm.popVstack().GetFloat()
+				left, _ := vm.popVstack().GetFloat()
+				vm.pushVstack(BoolVal(left < right))
+			} else {
+				vm.runtimeError("Operands must be numbers.")
+				return false
+			}
+		case OP_ADD:
+			if vm.peekVstack(0).IsFloat() && vm.peekVstack(1).IsFloat() {
+				right, _ := vm.popVstack().GetFloat()
+				left, _ := vm.popVstack().GetFloat()
+				vm.pushVstack(FloatVal(left + right))
+			} else if vm.peekVstack(0).IsString() && vm.peekVstack(1).IsString() {
+				right, _ := vm.popVstack().GetString()
+				left, _ := vm.popVstack().GetString()
+				if vm.config.MaxString > 0 && len(left)+len(right) > vm.config.MaxString {
+					vm.runtimeError("String length limit of %d exceeded.", vm.config.MaxString)
+					return false
+				}
+				vm.pushVstack(StringVal(left + right))
+			} else {
+				vm.runtimeError("Operands must be two numbers or two strings.")
+				return false
+			}
+		case OP_SUBTRACT:
+			if vm.peekVstack(0).IsFloat() && vm.peekVstack(1).IsFloat() {
+				right, _ := vm.popVstack().GetFloat()
+				left, _ := vm.popVstack().GetFloat()
+				vm.pushVstack(FloatVal(left - right))
+			} else {
+				vm.runtimeError("Operands must be numbers.")
+				return false
+			}
+		case OP_MULTIPLY:
+			if vm.peekVstack(0).IsFloat() && vm.peekVstack(1).IsFloat() {
+				right, _ := vm.popVstack().GetFloat()
+				left, _ := vm.popVstack().GetFloat()
+				vm.pushVstack(FloatVal(left * right))
+			} else {
+				vm.runtimeError("Operands must be numbers.")
+				return false
+			}
+		case OP_DIVIDE:
+			if vm.peekVstack(0).IsFloat() && vm.peekVstack(1).IsFloat() {
+				right, _ := vm.popVstack().GetFloat()
+				left, _ := vm.popVstack().GetFloat()
+				vm.pushVstack(FloatVal(left / right))
+			} else {
+				vm.runtimeError("Operands must be numbers.")
+				return false
+			}
+		case OP_RETURN:
+			result := vm.popVstack()
+			vm.closeUpvalues(frame.slots_base)
+			vm.frameCount--
+			if vm.frameCount == 0 {
+				vm.popVstack()
+				return true
+			}
+			vm.vstackCount = frame.slots_base
+			vm.pushVstack(result)
+			frame = &vm.frames[vm.frameCount-1]
+		case OP_PRINT:
+			fmt.Fprintf(vm.config.Stdout, "%s\n", vm.popVstack().String())
+		case OP_POP:
+			vm.popVstack()
+		case OP_DEFINE_GLOBAL, OP_DEFINE_GLOBAL_LONG:
+			slot := frame.closure.globals[frame.readIndexOf(instruction)]
+			vm.globals[slot] = globalVar{vm.peekVstack(0), true}
+			vm.popVstack()
+		case OP_GET_GLOBAL, OP_GET_GLOBAL_LONG:
+			slot := frame.closure.globals[frame.readIndexOf(instruction)]
+			if !vm.globals[slot].defined {
+				vm.undefinedGlobal(slot)
+				return false
+			}
+			vm.pushVstack(vm.globals[slot].value)
+		case OP_SET_GLOBAL, OP_SET_GLOBAL_LONG:
+			slot := frame.closure.globals[frame.readIndexOf(instruction)]
+			if !vm.globals[slot].defined {
+				vm.undefinedGlobal(slot)
+				return false
+			}
+			vm.globals[slot].value = vm.peekVstack(0)
+		case OP_GET_LOCAL, OP_GET_LOCAL_LONG:
+			slot := frame.readIndexOf(instruction)
+			vm.pushVstack(vm.vstack[frame.slots_base+slot])
+		case OP_SET_LOCAL, OP_SET_LOCAL_LONG:
+			slot := frame.readIndexOf(instruction)
+			vm.vstack[frame.slots_base+slot] = vm.peekVstack(0)
+		case OP_GET_UPVALUE, OP_GET_UPVALUE_LONG:
+			slot := frame.readIndexOf(instruction)
+			vm.pushVstack(*frame.closure.upvalues[slot].ref)
+		case OP_SET_UPVALUE, OP_SET_UPVALUE_LONG:
+			slot := frame.readIndexOf(instruction)
+			*frame.closure.upvalues[slot].ref = vm.peekVstack(0)
+		case OP_JUMP, OP_JUMP_LONG:
+			offset := frame.readJumpOf(instruction)
+			frame.ip += offset
+		case OP_JUMP_IF_FALSE, OP_JUMP_IF_FALSE_LONG:
+			offset := frame.readJumpOf(instruction)
+			if isfalsey(vm.peekVstack(0)) {
+				frame.ip += offset
+			}
+		case OP_LOOP, OP_LOOP_LONG:
+			offset := frame.readJumpOf(instruction)
+			frame.ip -= offset
+		case OP_CALL:
+			argCount := frame.readByte()
+			if !vm.callValue(vm.peekVstack(int(argCount)), int(argCount)) {
+				return false
+			}
+			frame = &vm.frames[vm.frameCount-1]
+		case OP_CLOSURE, OP_CLOSURE_LONG:
+			val := frame.readConstantOf(instruction)
+			function, ok := val.GetFunction()
+			if !ok {
+				vm.runtimeError("Expect LoxFunction obj for OP_CLOSURE.")
+				return false
+			}
+			closure := NewClosure(function)
+			closure.globals = frame.closure.globals
+			closure.caches = frame.closure.caches
+			vm.pushVstack(ClosureVal(closure))
+
+			for i := 0; i < len(closure.upvalues); i++ {
+				var isLocal bool
+				var index int
+				isLocal, index, frame.ip = frame.closure.function.chunk.upvalueDescriptor(frame.ip)
+				if isLocal {
+					closure.upvalues[i] = vm.CaptureUpvalue(&vm.vstack[frame.slots_base+index], frame.slots_base+index)
+				} else {
+					closure.upvalues[i] = frame.closure.upvalues[index]
+				}
+			}
+		case OP_CLOSE_UPVALUE:
+			vm.closeUpvalues(vm.vstackCount - 1)
+			vm.popVstack()
+		case OP_CLASS, OP_CLASS_LONG:
+			name, _ := frame.readConstantOf(instruction).GetString()
+			vm.pushVstack(ClassVal(NewClass(name)))
+		case OP_GET_PROPERTY, OP_GET_PROPERTY_LONG:
+			if !vm.peekVstack(0).IsInstance() {
+				vm.runtimeError("Only instances have properties.")
+				return false
+			}
+			instance, _ := vm.peekVstack(0).GetInstance()
+			name, _ := frame.readConstantOf(instruction).GetString()
+			cache := frame.readCacheOf(instruction)
+			val, ok := instance.getField(name)
+			if ok {
+				vm.popVstack()
+				vm.pushVstack(val)
+				break
+			}
+			if vm.bindMethod(instance.klass, name, cache) {
+				break
+			}
+			vm.runtimeError("Undefined property '%s'.", name)
+			vm.suggestFrom(name, instance.fieldNames(), maps.Keys(instance.klass.methods))
+			return false
+		case OP_SET_PROPERTY, OP_SET_PROPERTY_LONG:
+			if !vm.peekVstack(1).IsInstance() {
+				vm.runtimeError("Only instances have fields.")
+				return false
+			}
+			instance, _ := vm.peekVstack(1).GetInstance()
+			fieldName, _ := frame.readConstantOf(instruction).GetString()
+			instance.setField(fieldName, vm.peekVstack(0))
+			value := vm.popVstack()
+			vm.popVstack()
+			vm.pushVstack(value)
+		case OP_METHOD, OP_METHOD_LONG:
+			klass, isClass := vm.peekVstack(1).GetClass()
+			if !isClass {
+				vm.runtimeError("Only classes have methods.")
+				return false
+			}
+			if !vm.peekVstack(0).IsClosure() {
+				vm.runtimeError("Methods must be closures.")
+				return false
+			}
+			methodName, _ := frame.readConstantOf(instruction).GetString()
+			klass.defineMethod(methodName, vm.peekVstack(0))
+			vm.popVstack() // pop the closure obj
+		case OP_INVOKE, OP_INVOKE_LONG:
+			methodName, _ := frame.readConstantOf(instruction).GetString()
+			cache := frame.readCacheOf(instruction)
+			argCount := frame.readByte()
+			if !vm.invoke(methodName, int(argCount), cache) {
+				return false
+			}
+			frame = &vm.frames[vm.frameCount-1]
+		case OP_INHERIT:
+			superKlass, isClass := vm.peekVstack(1).GetClass()
+			if !isClass {
+				vm.runtimeError("Superclass must be a class.")
+				return false
+			}
+			subKlass, isClass := vm.peekVstack(0).GetClass()
+			if !isClass {
+				vm.runtimeError("Subclass must be a class.")
+				return false
+			}
+			subKlass.inherit(superKlass)
+			vm.popVstack()
+		case OP_GET_SUPER, OP_GET_SUPER_LONG:
+			methodName, _ := frame.readConstantOf(instruction).GetString()
+			cache := frame.readCacheOf(instruction)
+			superKlass, isClass := vm.peekVstack(0).GetClass()
+			if !isClass {
+				vm.runtimeError("Superclass must be a class.")
+				return false
+			}
+			vm.popVstack()
+			if vm.bindMethod(superKlass, methodName, cache) {
+				break
+			}
+			vm.runtimeError("Undefined property '%s'.", methodName)
+			vm.suggest(methodName, superKlass.methods)
+			return false
+		case OP_INVOKE_SUPER, OP_INVOKE_SUPER_LONG:
+			methodName, _ := frame.readConstantOf(instruction).GetString()
+			cache := frame.readCacheOf(instruction)
+			argCount := frame.readByte()
+			superKlass, isClass := vm.peekVstack(0).GetClass()
+			if !isClass {
+				vm.runtimeError("Superclass must be a class.")
+				return false
+			}
+			vm.popVstack()
+			if !vm.invokeFromClass(superKlass, methodName, int(argCount), cache) {
+				return false
+			}
+			frame = &vm.frames[vm.frameCount-1]
+		}
+	}
+	return true
+}
+
+// globalSlot returns the slot of the global variable name, adding an
+// undefined one the first time the name is seen. Slots are never removed, so
+// closures linked to them stay valid for the life of the VM.
+func (vm *VM) globalSlot(name string) int {
+	if slot, ok := vm.globalSlots[name]; ok {
+		return slot
+	}
+	vm.globalSlots[name] = len(vm.globals)
+	vm.globals = append(vm.globals, globalVar{})
+	vm.globalNames = append(vm.globalNames, name)
+	return len(vm.globals) - 1
+}
+
+// link maps the global slots a program was compiled with to the VM's own, so
+// globals defined by one script are visible to the next.
+func (vm *VM) link(names []string) []int {
+	slots := make([]int, len(names))
+	for i, name := range names {
+		slots[i] = vm.globalSlot(name)
+	}
+	return slots
+}
+
+func (vm *VM) undefinedGlobal(slot int) {
+	name := vm.globalNames[slot]
+	vm.runtimeError("Undefined variable '%s'.", name)
+	vm.suggestFrom(name, vm.definedGlobals())
+}
+
+// definedGlobals yields the names of the globals that have been defined.
+func (vm *VM) definedGlobals() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for slot, global := range vm.globals {
+			if global.defined && !yield(vm.globalNames[slot]) {
+				return
+			}
+		}
+	}
+}
+
+func (vm *VM) DefineNative(name string, function NativeFn) {
+	vm.globals[vm.globalSlot(name)] = globalVar{NativeVal(function), true}
+}
+
+// GetGlobal returns the current value of the global variable name.
+func (vm *VM) GetGlobal(name string) (Value, bool) {
+	slot, ok := vm.globalSlots[name]
+	if !ok || !vm.globals[slot].defined {
+		return NilVal(), false
+	}
+	return vm.globals[slot].value, true
+}
+
+// GlobalNames lists every defined global variable in sorted order.
+func (vm *VM) GlobalNames() []string {
+	return slices.Sorted(vm.definedGlobals())
+}
+
+// SetTrace switches the per-instruction execution trace on or off.
+func (vm *VM) SetTrace(trace bool) {
+	vm.config.Trace = trace
+}
+
+func NewVM(config Config) *VM {
+	vm := &VM{config: config.withDefaults(), startTime: time.Now(), globalSlots: make(map[string]int)}
+	vm.resetStack()
+	vm.DefineNative("clock", ClockNative)
+	vm.DefineNative("argc", ArgcNative)
+	vm.DefineNative("arg", ArgNative)
+	vm.DefineNative("env", EnvNative)
+	vm.DefineNative("exit", ExitNative)
+	return vm
+}
+
+// Interpret runs a compiled script. A failure is reported as a *RuntimeError,
+// a call to the exit() native as an *ExitError.
+// Globals defined by earlier calls stay visible, so one VM can run a whole
+// REPL session line by line.
+func (vm *VM) Interpret(function *LoxFunction) error {
+	clousre := NewClosure(function)
+	clousre.globals = vm.link(function.chunk.globals)
+	clousre.caches = make([]methodCache, function.chunk.caches)
+	vm.pushVstack(ClosureVal(clousre))
+
+	vm.err = nil
+	vm.steps = 0
+	if !vm.call(clousre, 0) || !vm.runVM() {
+		return vm.err
+	}
+	return nil
+}
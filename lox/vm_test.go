@@ -0,0 +1,396 @@
+package lox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testcaseDir = "../testcase"
+
+// func_06.lox times fib(35) with clock() and is far too slow to run several
+// times over under -race, or when -short asks for a quick run.
+var slowTestcases = map[string]bool{"func_06.lox": true}
+
+func loadTestcases(t testing.TB) map[string]string {
+	t.Helper()
+	scripts := make(map[string]string)
+	err := filepath.WalkDir(testcaseDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".lox") {
+			return err
+		}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		scripts[filepath.ToSlash(path)] = string(src)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scripts
+}
+
+func runScript(source string) string {
+	var out bytes.Buffer
+	config := Config{Stdout: &out}
+	function, err := Compile(source, config)
+	if err != nil {
+		return err.Error()
+	}
+	if err := NewVM(config).Interpret(function); err != nil {
+		out.WriteString(err.Error())
+	}
+	return out.String()
+}
+
+func TestTestcasesRunConcurrently(t *testing.T) {
+	scripts := loadTestcases(t)
+	want := make(map[string]string)
+	for path, source := range scripts {
+		if (raceEnabled || testing.Short()) && slowTestcases[filepath.Base(path)] {
+			delete(scripts, path)
+			continue
+		}
+		want[path] = runScript(source)
+	}
+
+	const runs = 4
+	for path, source := range scripts {
+		t.Run(path, func(t *testing.T) {
+			t.Parallel()
+			var wg sync.WaitGroup
+			got := make([]string, runs)
+			for i := range runs {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					got[i] = runScript(source)
+				}()
+			}
+			wg.Wait()
+			for i := range runs {
+				if got[i] != want[path] {
+					t.Errorf("run %d output:\n%s\nwant:\n%s", i, got[i], want[path])
+				}
+			}
+		})
+	}
+}
+
+func TestUndefinedNameSuggestions(t *testing.T) {
+	tests := []struct {
+		source string
+		hint   string
+	}{
+		{"var counter = 1; print countr;", "did you mean 'counter'?"},
+		{"var counter = 1; conuter = 2;", "did you mean 'counter'?"},
+		{"class P { area() {} } var p = P(); p.width = 1; print p.widht;", "did you mean 'width'?"},
+		{"class P { area() {} } P().aera();", "did you mean 'area'?"},
+		{"var counter = 1; print total;", ""},
+		{"fun f() {} print y;", ""},
+		{"var ab = 1; print ac;", ""},
+		{"var cat = 1; print cta;", "did you mean 'cat'?"},
+	}
+	for _, test := range tests {
+		function, err := Compile(test.source, Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = NewVM(Config{Stdout: io.Discard}).Interpret(function)
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) || runtimeErr.Hint != test.hint {
+			t.Errorf("%s: got %v, want hint %q", test.source, err, test.hint)
+		}
+	}
+}
+
+func TestGlobalsLinkAcrossScripts(t *testing.T) {
+	var out strings.Builder
+	config := Config{Stdout: &out}
+	vm := NewVM(config)
+	run := func(source string) error {
+		t.Helper()
+		function, err := Compile(source, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return vm.Interpret(function)
+	}
+	// each script numbers its globals from 0, so later and a get different
+	// slots here than in the scripts below
+	if err := run("fun show() { print later; }\nvar a = 1;"); err != nil {
+		t.Fatal(err)
+	}
+	for _, source := range []string{"show();", "later = 1;"} {
+		var runtimeErr *RuntimeError
+		if err := run(source); !errors.As(err, &runtimeErr) || runtimeErr.Message != "Undefined variable 'later'." {
+			t.Errorf("%s: got %v, want later to be undefined", source, err)
+		}
+	}
+	if _, ok := vm.GetGlobal("later"); ok || slices.Contains(vm.GlobalNames(), "later") {
+		t.Errorf("a failed assignment defined later")
+	}
+	if err := run("var later = \"late\";\na = a + 1;\nshow();\nprint a;"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "late\n2\n"; got != want {
+		t.Errorf("output %q, want %q", got, want)
+	}
+}
+
+func TestMethodCacheFollowsClassChanges(t *testing.T) {
+	first, second := NewClosure(NewFunction()), NewClosure(NewFunction())
+	base, derived := NewClass("Base"), NewClass("Derived")
+	var cache methodCache
+	lookup := func(klass *LoxClass) *LoxClosure {
+		method, _ := findMethod(klass, "m", &cache)
+		return method
+	}
+
+	base.defineMethod("m", ClosureVal(first))
+	if lookup(base) != first || lookup(base) != first {
+		t.Fatalf("lookup in Base did not find m")
+	}
+	if _, ok := findMethod(derived, "m", &cache); ok {
+		t.Errorf("the cache for Base answered for Derived")
+	}
+	derived.inherit(base)
+	if lookup(derived) != first {
+		t.Errorf("inherit did not invalidate the cache for Derived")
+	}
+	derived.defineMethod("m", ClosureVal(second))
+	if lookup(derived) != second {
+		t.Errorf("overriding m did not invalidate the cache for Derived")
+	}
+	if lookup(base) != first {
+		t.Errorf("the cache for Derived answered for Base")
+	}
+}
+
+func TestInstancesShareShapes(t *testing.T) {
+	var src strings.Builder
+	src.WriteString("class P {}\n")
+	src.WriteString("var a = P(); a.x = 1; a.y = 2;\n")
+	src.WriteString("var b = P(); b.x = 3; b.y = 4; b.x = 5;\n")
+	src.WriteString("var c = P(); c.y = 6; c.x = 7;\n")
+	// too many fields for one shape
+	src.WriteString("var wide = P();\n")
+	for i := range MAX_SHAPE_FIELDS + 1 {
+		fmt.Fprintf(&src, "wide.f%d = %d;\n", i, i)
+	}
+	// too many shapes for one class, as when instances are used as maps
+	for i := range MAX_CLASS_SHAPES {
+		fmt.Fprintf(&src, "var m%d = P(); m%d.k%d = %d;\n", i, i, i, i)
+	}
+	last := fmt.Sprintf("m%d", MAX_CLASS_SHAPES-1)
+	fmt.Fprintf(&src, "print a.x + b.x + c.x + wide.f0 + wide.f%d + %s.k%d;\n", MAX_SHAPE_FIELDS, last, MAX_CLASS_SHAPES-1)
+
+	var out strings.Builder
+	config := Config{Stdout: &out}
+	function, err := Compile(src.String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := NewVM(config)
+	if err := vm.Interpret(function); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintln(1 + 5 + 7 + 0 + MAX_SHAPE_FIELDS + MAX_CLASS_SHAPES - 1); out.String() != want {
+		t.Errorf("output %q, want %q", out.String(), want)
+	}
+	instance := func(name string) *LoxInstance {
+		value, _ := vm.GetGlobal(name)
+		instance, _ := value.GetInstance()
+		return instance
+	}
+	if instance("a").shape != instance("b").shape {
+		t.Errorf("instances with the same fields don't share a shape")
+	}
+	if instance("a").shape == instance("c").shape {
+		t.Errorf("fields added in another order share a shape")
+	}
+	if instance("wide").shape != nil || instance(last).shape != nil {
+		t.Errorf("instances past the shape limits still have a shape")
+	}
+	if instance("m0").shape == nil {
+		t.Errorf("instance within the class shape limit lost its shape")
+	}
+}
+
+func TestMaxStringStopsRunawayConcatenation(t *testing.T) {
+	// well within the instruction budget, but doubling s every time
+	config := Config{Stdout: io.Discard, MaxSteps: fuzzMaxSteps, MaxString: fuzzMaxString}
+	function, err := Compile("var s = \"a\";\nwhile (true) s = s + s;\n", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewVM(config).Interpret(function)
+	var runtimeErr *RuntimeError
+	if want := fmt.Sprintf("String length limit of %d exceeded.", fuzzMaxString); !errors.As(err, &runtimeErr) || runtimeErr.Message != want {
+		t.Fatalf("got %v, want %q", err, want)
+	}
+	if got := runScript("var s = \"ab\"; print s + s;"); got != "abab\n" {
+		t.Errorf("without a limit: got %q", got)
+	}
+}
+
+func TestMaxStepsStopsInfiniteLoop(t *testing.T) {
+	config := Config{Stdout: io.Discard, MaxSteps: 1000}
+	function, err := Compile("var i = 0;\nwhile (true) i = i + 1;\n", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := NewVM(config)
+	err = vm.Interpret(function)
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.Message != "Instruction budget of 1000 exhausted." {
+		t.Fatalf("got %v, want the budget to run out", err)
+	}
+	// the budget is per call, not per VM
+	function, _ = Compile("print 1;", config)
+	if err := vm.Interpret(function); err != nil {
+		t.Errorf("second script: %v", err)
+	}
+}
+
+func TestArithmeticDoesNotAllocate(t *testing.T) {
+	config := Config{Stdout: io.Discard}
+	function, err := Compile("var x = 0;\nfor (var i = 0; i < 1000; i = i + 1) x = x + i * 2 - 1;\n", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := NewVM(config)
+	allocs := testing.AllocsPerRun(10, func() {
+		if err := vm.Interpret(function); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// the script's closure and frame, not one per number
+	if allocs > 10 {
+		t.Errorf("%v allocations per run, want the loop not to allocate", allocs)
+	}
+}
+
+func TestVMKeepsStateAcrossInterpretCalls(t *testing.T) {
+	var out strings.Builder
+	config := Config{Stdout: &out}
+	vm := NewVM(config)
+	inputs := []string{
+		"var count = 0;",
+		"class Counter { inc() { count = count + 1; return this; } }",
+		"fun fail() { var c = Counter(); c.inc(); return c + 1; }",
+		"fail();",
+		"Counter().inc().inc();",
+		"print count;",
+	}
+	for _, source := range inputs {
+		function, err := Compile(source, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = vm.Interpret(function)
+		if source == "fail();" {
+			var runtimeErr *RuntimeError
+			if !errors.As(err, &runtimeErr) {
+				t.Fatalf("%s: got %v, want a runtime error", source, err)
+			}
+		} else if err != nil {
+			t.Fatalf("%s: %v", source, err)
+		}
+	}
+	// the failed call's frames are gone but its increment stays
+	if got := out.String(); got != "3\n" {
+		t.Errorf("got %q, want %q", got, "3\n")
+	}
+}
+
+func TestScriptNatives(t *testing.T) {
+	t.Setenv("GLOX_TEST_ENV", "set")
+	var out strings.Builder
+	config := Config{Stdout: &out, Args: []string{"first", "second"}}
+	source := `
+for (var i = 0; i < argc(); i = i + 1) print arg(i);
+print env("GLOX_TEST_ENV");
+print env("GLOX_TEST_UNSET");
+exit(3);
+print "not reached";
+`
+	function, err := Compile(source, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewVM(config).Interpret(function)
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 3 {
+		t.Errorf("got %v, want exit status 3", err)
+	}
+	if got, want := out.String(), "first\nsecond\nset\nnil\n"; got != want {
+		t.Errorf("output %q, want %q", got, want)
+	}
+
+	for _, code := range []int{0, 255} {
+		function, err := Compile(fmt.Sprintf("exit(%d);", code), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = NewVM(config).Interpret(function)
+		if !errors.As(err, &exitErr) || exitErr.Code != code {
+			t.Errorf("exit(%d): got %v", code, err)
+		}
+	}
+	for _, source := range []string{"arg(2);", "arg(-1);", "arg(0.5);", "arg();", "argc(1);", "env(1);", "exit(1.5);", "exit(nil);", "exit(-1);", "exit(256);", "exit(100000000000000000000);"} {
+		function, err := Compile(source, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var runtimeErr *RuntimeError
+		if err := NewVM(config).Interpret(function); !errors.As(err, &runtimeErr) {
+			t.Errorf("%s: got %v, want a runtime error", source, err)
+		}
+	}
+}
+
+func TestStackGrowsPastItsInitialSize(t *testing.T) {
+	// more live locals than the stack starts with, and no calls
+	var block strings.Builder
+	block.WriteString("{\n")
+	for i := range VSTACK_INITIAL + 1000 {
+		fmt.Fprintf(&block, "var v%d = %d;\n", i, i)
+	}
+	fmt.Fprintf(&block, "print v0 + v%d;\n}\n", VSTACK_INITIAL+999)
+	if got, want := runScript(block.String()), fmt.Sprintf("%d\n", VSTACK_INITIAL+999); got != want {
+		t.Errorf("block: got %q, want %q", got, want)
+	}
+
+	// frames of 300 locals outgrow it at a depth of about 54, and the stack
+	// moves while the upvalue for x is open
+	var deep strings.Builder
+	deep.WriteString("fun down(n, set) {\n")
+	for i := range 300 {
+		fmt.Fprintf(&deep, "var v%d = %d;\n", i, i)
+	}
+	deep.WriteString(`  if (n == 0) { set(); return v299; }
+  return down(n - 1, set);
+}
+fun outer() {
+  var x = "before";
+  fun set() { x = "after"; }
+  print down(60, set);
+  return x;
+}
+print outer();
+`)
+	if got, want := runScript(deep.String()), "299\nafter\n"; got != want {
+		t.Errorf("recursion: got %q, want %q", got, want)
+	}
+}
@@ -1,69 +1,413 @@
 package main
 
 import (
-	"bufio"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+
+	"glox/bench"
+	"glox/lox"
 )
 
-func Init() {
-	ScannerInit()
+const usage = `Usage:
+  glox                          start the REPL
+  glox [flags] <file> [args...] same as glox run
+  glox run [flags] <file> [args...]
+                                compile and run a script
+  glox check [flags] <file>     compile only and report diagnostics
+  glox tokens <file>            print the token stream
+  glox disasm [flags] <file>    print the bytecode of every function without running it
+  glox compile <file> [-o out]  write the compiled bytecode to out, default <file>c
+  glox eval [flags] '<code>' [args...]
+                                run code given on the command line
+  glox bench <old> <new>        compare two saved runs of go test -bench ./bench
+  glox help                     show this message
+
+A <file> of "-" reads the program from stdin. run, check and disasm also
+accept bytecode written by glox compile. Scripts see [args...] through
+the argc() and arg(n) natives, and exit(code) ends glox with that status.
+
+Flags for run and eval:
+  -tokens   print the token stream before compiling
+  -disasm   print the bytecode of every function after compiling
+  -trace    print the stack and each instruction while running
+  -D        all of the above
+
+Flags for run, eval, check, disasm and compile:
+  --compat  match clox's output and error messages byte for byte (default
+            true); --compat=false adds a banner before running and reports
+            errors in the text format
+  --error-format=clox|text|json
+            report errors as clox does, with source snippets (colored on
+            a terminal), or as one JSON object per line for tools; the
+            default follows --compat
+`
+
+// usageError is returned for malformed command lines.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg + "\n" + usage
+}
+
+type command func(args []string) error
+
+var commands = map[string]command{
+	"run":     cmdRun,
+	"check":   cmdCheck,
+	"tokens":  cmdTokens,
+	"disasm":  cmdDisasm,
+	"compile": cmdCompile,
+	"eval":    cmdEval,
+	"bench":   cmdBench,
+	"help":    cmdHelp,
 }
 
 func main() {
-	Init()
-	switch len(os.Args) {
-	case 1:
-		Repl()
-	case 2:
-		if err := RunFile(os.Args[1]); err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(64)
-		}
-	case 3:
-		if os.Args[1] == "-D" {
-			DebugFlag = true
-		}
-		if err := RunFile(os.Args[2]); err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(64)
-		}
+	if len(os.Args) == 1 {
+		Repl(lox.Config{})
+		return
+	}
+	cmd, ok := commands[os.Args[1]]
+	args := os.Args[2:]
+	if !ok {
+		cmd, args = cmdRun, os.Args[1:]
+	}
+	if err := cmd(args); err != nil {
+		reportError(err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// sourceError is an error from compiling or running a program, kept with the
+// program's source so reportError can show the lines it points at.
+type sourceError struct {
+	err         error
+	source      string
+	errorFormat string
+}
+
+func (e *sourceError) Error() string {
+	return e.err.Error()
+}
+
+func (e *sourceError) Unwrap() error {
+	return e.err
+}
+
+func withSource(err error, source string, errorFormat string) error {
+	if err == nil {
+		return nil
+	}
+	if lox.IsBytecode([]byte(source)) {
+		source = "" // positions refer to the original script
+	}
+	return &sourceError{err, source, errorFormat}
+}
+
+// reportError prints err to stderr in the format it was requested in. A
+// script calling exit() is not an error worth printing.
+func reportError(err error) {
+	var exitErr *lox.ExitError
+	if errors.As(err, &exitErr) {
+		return
+	}
+	var srcErr *sourceError
+	if !errors.As(err, &srcErr) {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	switch srcErr.errorFormat {
+	case "clox":
+		lox.WriteErrorClox(os.Stderr, srcErr.err)
+	case "json":
+		lox.WriteErrorJSON(os.Stderr, srcErr.err, srcErr.source)
 	default:
-		fmt.Fprintln(os.Stderr, "Usage: glox [path]")
-		os.Exit(64)
+		lox.WriteError(os.Stderr, srcErr.err, srcErr.source, colorEnabled(os.Stderr))
 	}
 }
 
-func Repl() {
-	in := bufio.NewScanner(os.Stdin)
-	for {
-		fmt.Print("> ")
-		if !in.Scan() {
-			break
+// colorEnabled reports whether f is a terminal and NO_COLOR is not set.
+func colorEnabled(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// reportFlags are the flags every command that compiles accepts.
+type reportFlags struct {
+	compat      bool   // behave like clox, which is what the test suite expects
+	errorFormat string // "clox", "text" or "json"
+}
+
+// newFlagSet returns the flags of a subcommand, starting with --compat and
+// --error-format.
+func newFlagSet(name string, report *reportFlags) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.BoolVar(&report.compat, "compat", true, "")
+	flags.StringVar(&report.errorFormat, "error-format", "", "")
+	return flags
+}
+
+func parseFlagSet(flags *flag.FlagSet, args []string, report *reportFlags) error {
+	if err := flags.Parse(args); err != nil {
+		return &usageError{err.Error()}
+	}
+	switch report.errorFormat {
+	case "":
+		report.errorFormat = "text"
+		if report.compat {
+			report.errorFormat = "clox"
 		}
-		line := in.Text()
-		// TODO: interpreter line
-		fmt.Println("got:", line)
+	case "clox", "text", "json":
+	default:
+		return &usageError{fmt.Sprintf("unknown error format %q, expected clox, text or json.", report.errorFormat)}
+	}
+	return nil
+}
+
+// options holds the debug flags shared by run and eval.
+type options struct {
+	reportFlags
+	tokens bool
+	config lox.Config
+}
+
+func parseFlags(name string, args []string) (*options, []string, error) {
+	var opts options
+	var all bool
+	flags := newFlagSet(name, &opts.reportFlags)
+	flags.BoolVar(&opts.tokens, "tokens", false, "")
+	flags.BoolVar(&opts.config.Disassemble, "disasm", false, "")
+	flags.BoolVar(&opts.config.Trace, "trace", false, "")
+	flags.BoolVar(&all, "D", false, "")
+	if err := parseFlagSet(flags, args, &opts.reportFlags); err != nil {
+		return nil, nil, err
+	}
+	if all {
+		opts.tokens, opts.config.Disassemble, opts.config.Trace = true, true, true
+	}
+	return &opts, flags.Args(), nil
+}
+
+// fileArg checks that args is exactly one path and reads it.
+func fileArg(name string, args []string) (string, string, error) {
+	if len(args) != 1 {
+		return "", "", &usageError{fmt.Sprintf("%s expects exactly one file.", name)}
+	}
+	source, err := readSource(args[0])
+	return args[0], source, err
+}
+
+// sourceName is the file name error positions refer to; stdin has none.
+func sourceName(path string) string {
+	if path == "-" {
+		return ""
+	}
+	return path
+}
+
+// readSource reads a script from path, or from stdin when path is "-".
+func readSource(path string) (string, error) {
+	var src []byte
+	var err error
+	if path == "-" {
+		src, err = io.ReadAll(os.Stdin)
+	} else {
+		src, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", err
 	}
+	return string(src), nil
+}
+
+func cmdHelp(args []string) error {
+	fmt.Print(usage)
+	return nil
 }
 
-func RunFile(path string) error {
-	src, err := os.ReadFile(path)
+func cmdRun(args []string) error {
+	opts, args, err := parseFlags("run", args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return &usageError{"run expects a file."}
+	}
+	source, err := readSource(args[0])
 	if err != nil {
 		return err
 	}
-	return Run(string(src))
+	opts.config.Args = args[1:]
+	opts.config.File = sourceName(args[0])
+	return withSource(Run(source, opts), source, opts.errorFormat)
 }
 
-func Run(source string) error {
-	if DebugFlag {
-		DumpTokens(source)
+func cmdEval(args []string) error {
+	opts, args, err := parseFlags("eval", args)
+	if err != nil {
+		return err
 	}
-	ok, function := Compile(source)
-	if !ok {
-		return errors.New("glox compile fail")
+	if len(args) == 0 {
+		return &usageError{"eval expects a code argument."}
+	}
+	opts.config.Args = args[1:]
+	return withSource(Run(args[0], opts), args[0], opts.errorFormat)
+}
+
+func cmdCheck(args []string) error {
+	var report reportFlags
+	flags := newFlagSet("check", &report)
+	if err := parseFlagSet(flags, args, &report); err != nil {
+		return err
+	}
+	path, source, err := fileArg("check", flags.Args())
+	if err != nil {
+		return err
+	}
+	_, err = loadProgram(source, lox.Config{File: sourceName(path)})
+	return withSource(err, source, report.errorFormat)
+}
+
+func cmdTokens(args []string) error {
+	_, source, err := fileArg("tokens", args)
+	if err != nil {
+		return err
+	}
+	lox.DumpTokens(os.Stdout, source)
+	return nil
+}
+
+func cmdDisasm(args []string) error {
+	var report reportFlags
+	flags := newFlagSet("disasm", &report)
+	if err := parseFlagSet(flags, args, &report); err != nil {
+		return err
+	}
+	path, source, err := fileArg("disasm", flags.Args())
+	if err != nil {
+		return err
+	}
+	function, err := loadProgram(source, lox.Config{File: sourceName(path)})
+	if err != nil {
+		return withSource(err, source, report.errorFormat)
 	}
-	Interprete(function)
+	lox.DisassembleFunction(os.Stdout, function)
 	return nil
 }
+
+func cmdCompile(args []string) error {
+	var output string
+	var report reportFlags
+	flags := newFlagSet("compile", &report)
+	flags.StringVar(&output, "o", "", "")
+	// accept flags both before and after the file name
+	if err := flags.Parse(args); err != nil {
+		return &usageError{err.Error()}
+	}
+	if flags.NArg() == 0 {
+		return &usageError{"compile expects a file."}
+	}
+	path := flags.Arg(0)
+	if err := parseFlagSet(flags, flags.Args()[1:], &report); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return &usageError{"compile expects exactly one file."}
+	}
+	if output == "" {
+		if path == "-" {
+			return &usageError{"compile needs -o when reading from stdin."}
+		}
+		output = strings.TrimSuffix(path, ".lox") + ".loxc"
+	}
+
+	source, err := readSource(path)
+	if err != nil {
+		return err
+	}
+	function, err := lox.Compile(source, lox.Config{File: sourceName(path)})
+	if err != nil {
+		return withSource(err, source, report.errorFormat)
+	}
+	data, err := lox.MarshalFunction(function)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output, data, 0644)
+}
+
+// cmdBench prints how the benchmarks in the go test -bench output saved in
+// args[1] compare with those saved in args[0].
+func cmdBench(args []string) error {
+	if len(args) != 2 {
+		return &usageError{"bench expects two files of go test -bench output."}
+	}
+	var runs [2]map[string]bench.Result
+	for i, path := range args {
+		source, err := readSource(path)
+		if err != nil {
+			return err
+		}
+		if runs[i], err = bench.ParseResults(strings.NewReader(source)); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if len(runs[i]) == 0 {
+			return fmt.Errorf("%s: no benchmark results", path)
+		}
+	}
+	return bench.WriteComparison(os.Stdout, runs[0], runs[1])
+}
+
+// loadProgram compiles source, or decodes it when it is bytecode written by
+// glox compile.
+func loadProgram(source string, config lox.Config) (*lox.LoxFunction, error) {
+	if lox.IsBytecode([]byte(source)) {
+		return lox.UnmarshalFunction([]byte(source))
+	}
+	return lox.Compile(source, config)
+}
+
+func Run(source string, opts *options) error {
+	if opts.tokens && !lox.IsBytecode([]byte(source)) {
+		lox.DumpTokens(os.Stdout, source)
+	}
+	function, err := loadProgram(source, opts.config)
+	if err != nil {
+		return err
+	}
+	if !opts.compat {
+		fmt.Printf("-- GLOX VM --\n")
+	}
+	vm := lox.NewVM(opts.config)
+	return vm.Interpret(function)
+}
+
+// exitCode maps an error to the sysexits.h status clox uses for it.
+func exitCode(err error) int {
+	var usageErr *usageError
+	var compileErr *lox.CompileError
+	var runtimeErr *lox.RuntimeError
+	var exitErr *lox.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		return exitErr.Code
+	case errors.As(err, &usageErr):
+		return 64 // EX_USAGE
+	case errors.As(err, &compileErr), errors.Is(err, lox.ErrBadBytecode):
+		return 65 // EX_DATAERR
+	case errors.As(err, &runtimeErr):
+		return 70 // EX_SOFTWARE
+	default:
+		return 74 // EX_IOERR
+	}
+}
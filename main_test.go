@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain runs main instead of the tests when GLOX_TEST_MAIN is set, so the
+// tests can start the test binary as glox and see its output and exit status.
+func TestMain(m *testing.M) {
+	if os.Getenv("GLOX_TEST_MAIN") != "" {
+		os.Args = append([]string{"glox"}, os.Args[1:]...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// glox runs glox with args, feeding it stdin, and returns what it wrote and
+// its exit status.
+func glox(t *testing.T, stdin string, args ...string) (stdout string, stderr string, code int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "GLOX_TEST_MAIN=1", "NO_COLOR=1")
+	cmd.Stdin = bytes.NewBufferString(stdin)
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return out.String(), errOut.String(), code
+}
+
+func TestReplSaveWritesARunnableScript(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.lox")
+	glox(t, "var a = 1; a\nfun twice(n) { return 2 * n; }\ntwice(a) + 1\n:save "+path+"\n")
+
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "var a = 1; print a;\nfun twice(n) { return 2 * n; }\nprint twice(a) + 1;\n"
+	if string(saved) != want {
+		t.Errorf("saved\n%s\nwant\n%s", saved, want)
+	}
+	if stdout, stderr, code := glox(t, "", "run", path); stdout != "1\n3\n" || code != 0 {
+		t.Errorf("running the saved session: stdout %q, stderr %q, exit %d", stdout, stderr, code)
+	}
+}
+
+func TestReplKeepsStateBetweenInputs(t *testing.T) {
+	stdout, stderr, code := glox(t, "var a = 1;\nfun inc() { a = a + 1; }\ninc();\nprint nil + 1;\ninc();\nprint a;\n")
+	if want := "> > > > > > 3\n> \n"; stdout != want {
+		t.Errorf("stdout %q, want %q", stdout, want)
+	}
+	if !strings.Contains(stderr, "Operands must be two numbers or two strings.") {
+		t.Errorf("stderr %q, want the runtime error of the fourth input", stderr)
+	}
+	if code != 0 {
+		t.Errorf("exit status %d, want 0", code)
+	}
+}
+
+func TestReplReadsMultiLineInput(t *testing.T) {
+	stdout, stderr, _ := glox(t, "fun greet(name) {\n  return \"hi \" +\n    name;\n}\nvar s = \"a\nb\";\ngreet(\"you\")\ns\n")
+	if want := "> ... ... ... > ... > hi you\n> a\nb\n> \n"; stdout != want {
+		t.Errorf("stdout %q, want %q", stdout, want)
+	}
+	if stderr != "" {
+		t.Errorf("stderr %q", stderr)
+	}
+}
+
+func TestReplCommands(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "loaded.lox")
+	if err := os.WriteFile(script, []byte("var loaded = \"yes\";\nprint loaded;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	input := strings.Join([]string{
+		"fun add(a, b) { return a + b; }",
+		"var n = 1;",
+		":globals",
+		":dis add",
+		":dis n",
+		":load " + script,
+		"loaded",
+		":reset",
+		":globals",
+		"n",
+		":nope",
+		":quit",
+		"print \"not reached\";",
+	}, "\n")
+	stdout, stderr, code := glox(t, input)
+
+	for _, want := range []string{
+		fmt.Sprintf("  %-16s %-12s %s\n", "add", "function", "<fn add>"),
+		fmt.Sprintf("  %-16s %-12s %s\n", "n", "number", "1"),
+		"== <fn add> ==",
+		"OP_ADD",
+		"> yes\n> yes\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("stdout has no %q:\n%s", want, stdout)
+		}
+	}
+	// :reset dropped every global but the natives
+	if strings.Count(stdout, "  n ") != 1 || strings.Contains(stdout, "not reached") {
+		t.Errorf("stdout:\n%s", stdout)
+	}
+	for _, want := range []string{
+		"'n' is a number, not a function or class.",
+		"Undefined variable 'n'.",
+		"Unknown command ':nope', try :help.",
+	} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr has no %q:\n%s", want, stderr)
+		}
+	}
+	if code != 0 {
+		t.Errorf("exit status %d, want 0", code)
+	}
+}
+
+func TestSubcommands(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, source string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	good := write("good.lox", "print 1 + 2;\n")
+	bad := write("bad.lox", "print 1 +;\n")
+	fails := write("fails.lox", "print nil + 1;\n")
+	missing := filepath.Join(dir, "missing.lox")
+
+	tests := []struct {
+		args   []string
+		stdin  string
+		stdout string // expected to appear in the output
+		code   int
+	}{
+		{[]string{good}, "", "3\n", 0},
+		{[]string{"run", good}, "", "3\n", 0},
+		{[]string{"run", "-"}, "print 4;", "4\n", 0},
+		{[]string{"run", "--compat=false", good}, "", "-- GLOX VM --\n3\n", 0},
+		{[]string{"eval", "print 5;"}, "", "5\n", 0},
+		{[]string{"check", good}, "", "", 0},
+		{[]string{"tokens", good}, "", "<print>", 0},
+		{[]string{"disasm", good}, "", "OP_PRINT", 0},
+		{[]string{"help"}, "", "Usage:", 0},
+		{[]string{"run"}, "", "", 64},
+		{[]string{"check", good, bad}, "", "", 64},
+		{[]string{"run", "--error-format=xml", good}, "", "", 64},
+		{[]string{"run", "--nope", good}, "", "", 64},
+		{[]string{"check", bad}, "", "", 65},
+		{[]string{"run", bad}, "", "", 65},
+		{[]string{"run", fails}, "", "", 70},
+		{[]string{"eval", "print nil + 1;"}, "", "", 70},
+		{[]string{"run", missing}, "", "", 74},
+		{[]string{missing}, "", "", 74},
+	}
+	for _, test := range tests {
+		stdout, stderr, code := glox(t, test.stdin, test.args...)
+		if code != test.code || !strings.Contains(stdout, test.stdout) {
+			t.Errorf("glox %s: exit %d, stdout %q, stderr %q; want exit %d and %q in stdout",
+				strings.Join(test.args, " "), code, stdout, stderr, test.code, test.stdout)
+		}
+		if (code != 0) != (stderr != "") {
+			t.Errorf("glox %s: exit %d with stderr %q", strings.Join(test.args, " "), code, stderr)
+		}
+	}
+}
+
+func TestScriptArgumentsAndExit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tool.lox")
+	source := "#!/usr/bin/env glox\nfor (var i = 0; i < argc(); i = i + 1) print arg(i);\nexit(argc());\n"
+	if err := os.WriteFile(path, []byte(source), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{path, "a", "-b"}, {"run", path, "a", "-b"}} {
+		stdout, stderr, code := glox(t, "", args...)
+		if stdout != "a\n-b\n" || stderr != "" || code != 2 {
+			t.Errorf("glox %s: stdout %q, stderr %q, exit %d", strings.Join(args, " "), stdout, stderr, code)
+		}
+	}
+	if stdout, _, code := glox(t, "", "eval", "print arg(0); exit(0);", "x"); stdout != "x\n" || code != 0 {
+		t.Errorf("eval: stdout %q, exit %d", stdout, code)
+	}
+}
+
+func TestReplLoadedScriptCanExit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quits.lox")
+	if err := os.WriteFile(path, []byte("print \"bye\";\nexit(3);\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, code := glox(t, ":load "+path+"\nprint \"not reached\";\n")
+	if stdout != "> bye\n" || stderr != "" || code != 3 {
+		t.Errorf("stdout %q, stderr %q, exit %d; want the session to end with status 3", stdout, stderr, code)
+	}
+}
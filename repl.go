@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"glox/lox"
+)
+
+const (
+	replPrompt         = "> "
+	replContinuePrompt = "... "
+)
+
+// replSession is the state of one interactive session: the VM every input
+// runs against and the inputs that ran successfully, for :save.
+type replSession struct {
+	config  lox.Config
+	vm      *lox.VM
+	history []string
+	timing  bool
+}
+
+// Repl compiles and runs each input against one long-lived VM, so
+// variables, functions and classes declared earlier stay defined. An error
+// in one input is reported and the session carries on. Lines starting with
+// ':' are session commands, see replCommands.
+func Repl(config lox.Config) {
+	session := &replSession{config: config, vm: lox.NewVM(config)}
+	in := bufio.NewScanner(os.Stdin)
+	for {
+		source, ok := readInput(in)
+		if !ok {
+			fmt.Println()
+			break
+		}
+		input := strings.TrimSpace(source)
+		if input == "" {
+			continue
+		}
+		if strings.HasPrefix(input, ":") {
+			if !session.command(input) {
+				break
+			}
+			continue
+		}
+		if err := session.run(source); err != nil {
+			reportInputError(err)
+		}
+	}
+}
+
+// reportInputError ends the session with the script's status if err is from
+// a call to exit(), and reports it otherwise.
+func reportInputError(err error) {
+	var exitErr *lox.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.Code)
+	}
+	reportError(err)
+}
+
+// readInput reads one complete entry, prompting for continuation lines while
+// a string, '(' or '{' is still open. ok is false once stdin is exhausted and
+// nothing was read.
+func readInput(in *bufio.Scanner) (source string, ok bool) {
+	var lines []string
+	prompt := replPrompt
+	for {
+		fmt.Print(prompt)
+		if !in.Scan() {
+			return strings.Join(lines, "\n"), len(lines) > 0
+		}
+		lines = append(lines, in.Text())
+		source = strings.Join(lines, "\n")
+		if strings.HasPrefix(strings.TrimSpace(source), ":") || !lox.IsIncomplete(source) {
+			return source, true
+		}
+		prompt = replContinuePrompt
+	}
+}
+
+func (session *replSession) run(source string) error {
+	function, script, err := lox.CompileREPL(source, session.config)
+	if err != nil {
+		return withSource(err, source, "text")
+	}
+	start := time.Now()
+	err = session.vm.Interpret(function)
+	if session.timing {
+		fmt.Printf("(%v)\n", time.Since(start))
+	}
+	if err != nil {
+		return withSource(err, source, "text")
+	}
+	// echoed expressions are not valid outside the REPL, so :save gets the
+	// input with them turned into print statements
+	session.history = append(session.history, script)
+	return nil
+}
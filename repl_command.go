@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"glox/lox"
+)
+
+type replCommand struct {
+	usage string
+	help  string
+	run   func(session *replSession, args []string) error
+}
+
+var replCommands map[string]replCommand
+
+func init() {
+	replCommands = map[string]replCommand{
+		"help":    {":help", "list the session commands", (*replSession).cmdHelp},
+		"quit":    {":quit", "leave the REPL", nil},
+		"dis":     {":dis <name>", "disassemble a global function, class or method", (*replSession).cmdDis},
+		"globals": {":globals", "list global variables with their types", (*replSession).cmdGlobals},
+		"load":    {":load <file>", "run a file in the current session", (*replSession).cmdLoad},
+		"reset":   {":reset", "discard all definitions and start over", (*replSession).cmdReset},
+		"debug":   {":debug on|off", "trace every executed instruction", (*replSession).cmdDebug},
+		"time":    {":time", "toggle reporting the run time of each input", (*replSession).cmdTime},
+		"save":    {":save <file>", "write the successful inputs as a .lox script", (*replSession).cmdSave},
+	}
+}
+
+// command runs one ':' command line. It reports false when the session
+// should end.
+func (session *replSession) command(line string) bool {
+	fields := strings.Fields(strings.TrimPrefix(line, ":"))
+	if len(fields) == 0 {
+		fields = []string{"help"}
+	}
+	cmd, ok := replCommands[fields[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command ':%s', try :help.\n", fields[0])
+		return true
+	}
+	if cmd.run == nil {
+		return false
+	}
+	if err := cmd.run(session, fields[1:]); err != nil {
+		reportInputError(err)
+	}
+	return true
+}
+
+func (session *replSession) cmdHelp(args []string) error {
+	for _, name := range []string{"help", "dis", "globals", "load", "reset", "debug", "time", "save", "quit"} {
+		cmd := replCommands[name]
+		fmt.Printf("  %-16s %s\n", cmd.usage, cmd.help)
+	}
+	return nil
+}
+
+func (session *replSession) cmdDis(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Usage: %s", replCommands["dis"].usage)
+	}
+	value, ok := session.vm.GetGlobal(args[0])
+	if !ok {
+		return fmt.Errorf("Undefined variable '%s'.", args[0])
+	}
+	if !lox.DisassembleValue(os.Stdout, value) {
+		return fmt.Errorf("'%s' is a %s, not a function or class.", args[0], value.TypeName())
+	}
+	return nil
+}
+
+func (session *replSession) cmdGlobals(args []string) error {
+	for _, name := range session.vm.GlobalNames() {
+		value, _ := session.vm.GetGlobal(name)
+		fmt.Printf("  %-16s %-12s %s\n", name, value.TypeName(), value.String())
+	}
+	return nil
+}
+
+func (session *replSession) cmdLoad(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Usage: %s", replCommands["load"].usage)
+	}
+	src, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	config := session.config
+	config.File = args[0]
+	function, err := lox.Compile(string(src), config)
+	if err != nil {
+		return withSource(err, string(src), "text")
+	}
+	if err := session.vm.Interpret(function); err != nil {
+		return withSource(err, string(src), "text")
+	}
+	session.history = append(session.history, string(src))
+	return nil
+}
+
+func (session *replSession) cmdReset(args []string) error {
+	session.vm = lox.NewVM(session.config)
+	session.history = nil
+	return nil
+}
+
+func (session *replSession) cmdDebug(args []string) error {
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		return fmt.Errorf("Usage: %s", replCommands["debug"].usage)
+	}
+	session.config.Trace = args[0] == "on"
+	session.vm.SetTrace(session.config.Trace)
+	return nil
+}
+
+func (session *replSession) cmdTime(args []string) error {
+	session.timing = !session.timing
+	if session.timing {
+		fmt.Println("timing on")
+	} else {
+		fmt.Println("timing off")
+	}
+	return nil
+}
+
+func (session *replSession) cmdSave(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Usage: %s", replCommands["save"].usage)
+	}
+	var sb strings.Builder
+	for _, source := range session.history {
+		sb.WriteString(source)
+		sb.WriteString("\n")
+	}
+	return os.WriteFile(args[0], []byte(sb.String()), 0644)
+}